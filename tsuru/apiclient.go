@@ -0,0 +1,21 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	tsuruClient "github.com/tsuru/tsuru-client/tsuru/client"
+	"github.com/tsuru/tsuru/cmd"
+)
+
+// apiClient returns a client for the API of the current target. Requests are
+// sent by the given command client, which authenticates them and reports
+// error statuses as the other commands expect.
+func apiClient(client *cmd.Client) (*tsuruClient.Client, error) {
+	target, err := cmd.GetURL("")
+	if err != nil {
+		return nil, err
+	}
+	return tsuruClient.New(target, "", client), nil
+}
@@ -11,10 +11,12 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"strings"
 	"text/template"
 	"time"
 
+	tsuruClient "github.com/tsuru/tsuru-client/tsuru/client"
 	tsuruapp "github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/cmd"
 	tsuruIo "github.com/tsuru/tsuru/io"
@@ -27,6 +29,8 @@ type appCreate struct {
 	fs        *gnuflag.FlagSet
 }
 
+func (*appCreate) mutatesTarget() {}
+
 func (c *appCreate) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "app-create",
@@ -93,17 +97,22 @@ func (c *appCreate) Run(context *cmd.Context, client *cmd.Client) error {
 
 type appRemove struct {
 	cmd.GuessingCommand
-	cmd.ConfirmationCommand
+	protectedConfirmation
 	fs *gnuflag.FlagSet
 }
 
+func (*appRemove) mutatesTarget() {}
+
 func (c *appRemove) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "app-remove",
-		Usage: "app-remove [-a/--app appname] [-y/--assume-yes]",
+		Usage: "app-remove [-a/--app appname] [-y/--assume-yes] [--i-know-this-is-prod]",
 		Desc: `removes an app.
 
-If you don't provide the app name, tsuru will try to guess it.`,
+If you don't provide the app name, tsuru will try to guess it.
+
+Protected apps can only be removed after typing their names, or with
+--i-know-this-is-prod.`,
 		MinArgs: 0,
 	}
 }
@@ -113,10 +122,11 @@ func (c *appRemove) Run(context *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return err
 	}
-	if !c.Confirm(context, fmt.Sprintf(`Are you sure you want to remove app "%s"?`, appName)) {
-		return nil
+	ok, err := c.confirm(context, fmt.Sprintf(`Are you sure you want to remove app "%s"?`, appName), appName)
+	if !ok || err != nil {
+		return err
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s", appName))
+	url, err := cmd.GetURL(tsuruClient.Path("/apps/%s", appName))
 	if err != nil {
 		return err
 	}
@@ -136,7 +146,7 @@ func (c *appRemove) Flags() *gnuflag.FlagSet {
 	if c.fs == nil {
 		c.fs = cmd.MergeFlagSet(
 			c.GuessingCommand.Flags(),
-			c.ConfirmationCommand.Flags(),
+			c.protectedConfirmation.Flags(),
 		)
 	}
 	return c.fs
@@ -144,53 +154,56 @@ func (c *appRemove) Flags() *gnuflag.FlagSet {
 
 type appInfo struct {
 	cmd.GuessingCommand
+	outputCommand
+	fs *gnuflag.FlagSet
 }
 
 func (c *appInfo) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "app-info",
-		Usage: "app-info [-a/--app appname]",
+		Usage: "app-info [-a/--app appname] [--json | --yaml | --format <template> | -q/--quiet]",
 		Desc: `show information about your app.
 
-If you don't provide the app name, tsuru will try to guess it.`,
+If you don't provide the app name, tsuru will try to guess it.
+
+With --json, --yaml or --format, the app is printed along with its containers
+and service instances, in the given format.`,
 		MinArgs: 0,
 	}
 }
 
+func (c *appInfo) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = cmd.MergeFlagSet(
+			c.GuessingCommand.Flags(),
+			c.outputCommand.Flags(),
+		)
+	}
+	return c.fs
+}
+
 func (c *appInfo) Run(context *cmd.Context, client *cmd.Client) error {
 	appName, err := c.Guess()
 	if err != nil {
 		return err
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s", appName))
+	api, err := apiClient(client)
 	if err != nil {
 		return err
 	}
-	request, err := http.NewRequest("GET", url, nil)
+	a, err := api.GetApp(appName)
 	if err != nil {
 		return err
 	}
-	response, err := client.Do(request)
+	url, err := cmd.GetURL(tsuruClient.Path("/docker/node/apps/%s/containers", appName))
 	if err != nil {
 		return err
 	}
-	if response.StatusCode == http.StatusNoContent {
-		return nil
-	}
-	defer response.Body.Close()
-	result, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return err
-	}
-	url, err = cmd.GetURL(fmt.Sprintf("/docker/node/apps/%s/containers", appName))
-	if err != nil {
-		return err
-	}
-	request, err = http.NewRequest("GET", url, nil)
+	request, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return err
 	}
-	response, err = client.Do(request)
+	response, err := client.Do(request)
 	var adminResult []byte
 	if err == nil {
 		defer response.Body.Close()
@@ -199,7 +212,7 @@ func (c *appInfo) Run(context *cmd.Context, client *cmd.Client) error {
 			return err
 		}
 	}
-	url, err = cmd.GetURL(fmt.Sprintf("/services/instances?app=%s", appName))
+	url, err = cmd.GetURL(tsuruClient.Path("/services/instances?app=%s", appName))
 	if err != nil {
 		return err
 	}
@@ -216,34 +229,15 @@ func (c *appInfo) Run(context *cmd.Context, client *cmd.Client) error {
 			return err
 		}
 	}
-	return c.Show(result, adminResult, servicesResult, context)
-}
-
-type unit struct {
-	Name   string
-	Ip     string
-	Status string
-}
-
-func (u *unit) Available() bool {
-	return u.Status == "started" || u.Status == "unreachable"
+	return c.Show(&app{App: *a}, adminResult, servicesResult, context)
 }
 
+// app is an app along with the containers of its units and the service
+// instances bound to it, as shown by app-info.
 type app struct {
-	Ip         string
-	CName      []string
-	Name       string
-	Platform   string
-	Repository string
-	Teams      []string
-	Units      []unit
-	Ready      bool
-	Owner      string
-	TeamOwner  string
-	Deploys    uint
+	tsuruClient.App
 	containers []container
 	services   []serviceData
-	Plan       tsuruapp.Plan
 }
 
 type serviceData struct {
@@ -264,25 +258,6 @@ type container struct {
 	LastStatusUpdate time.Time
 }
 
-func (a *app) Addr() string {
-	cnames := strings.Join(a.CName, ", ")
-	if cnames != "" {
-		return fmt.Sprintf("%s, %s", cnames, a.Ip)
-	}
-	return a.Ip
-}
-
-func (a *app) IsReady() string {
-	if a.Ready {
-		return "Yes"
-	}
-	return "No"
-}
-
-func (a *app) GetTeams() string {
-	return strings.Join(a.Teams, ", ")
-}
-
 func (a *app) String() string {
 	format := `Application: {{.Name}}
 Repository: {{.Repository}}
@@ -351,22 +326,31 @@ Deploys: {{.Deploys}}
 	return buf.String() + suffix
 }
 
-func (c *appInfo) Show(result []byte, adminResult []byte, servicesResult []byte, context *cmd.Context) error {
-	var a app
-	err := json.Unmarshal(result, &a)
-	if err != nil {
-		return err
-	}
+func (c *appInfo) Show(a *app, adminResult []byte, servicesResult []byte, context *cmd.Context) error {
 	json.Unmarshal(adminResult, &a.containers)
 	json.Unmarshal(servicesResult, &a.services)
-	fmt.Fprintln(context.Stdout, &a)
+	if c.structured() {
+		output := appInfoOutput{app: a, Containers: a.containers, Services: a.services}
+		return c.write(context.Stdout, output, []string{a.Name})
+	}
+	fmt.Fprintln(context.Stdout, a)
 	return nil
 }
 
+// appInfoOutput is the document printed by app-info --json and --yaml: the
+// fields of the app, along with its containers and service instances.
+type appInfoOutput struct {
+	*app
+	Containers []container
+	Services   []serviceData
+}
+
 type appGrant struct {
 	cmd.GuessingCommand
 }
 
+func (*appGrant) mutatesTarget() {}
+
 func (c *appGrant) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "app-grant",
@@ -384,7 +368,7 @@ func (c *appGrant) Run(context *cmd.Context, client *cmd.Client) error {
 		return err
 	}
 	teamName := context.Args[0]
-	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/teams/%s", appName, teamName))
+	url, err := cmd.GetURL(tsuruClient.Path("/apps/%s/teams/%s", appName, teamName))
 	if err != nil {
 		return err
 	}
@@ -404,6 +388,8 @@ type appRevoke struct {
 	cmd.GuessingCommand
 }
 
+func (*appRevoke) mutatesTarget() {}
+
 func (c *appRevoke) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "app-revoke",
@@ -421,7 +407,7 @@ func (c *appRevoke) Run(context *cmd.Context, client *cmd.Client) error {
 		return err
 	}
 	teamName := context.Args[0]
-	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/teams/%s", appName, teamName))
+	url, err := cmd.GetURL(tsuruClient.Path("/apps/%s/teams/%s", appName, teamName))
 	if err != nil {
 		return err
 	}
@@ -437,37 +423,37 @@ func (c *appRevoke) Run(context *cmd.Context, client *cmd.Client) error {
 	return nil
 }
 
-type appList struct{}
+type appList struct {
+	outputCommand
+}
 
 func (c appList) Run(context *cmd.Context, client *cmd.Client) error {
-	url, err := cmd.GetURL("/apps")
-	if err != nil {
-		return err
-	}
-	request, err := http.NewRequest("GET", url, nil)
+	api, err := apiClient(client)
 	if err != nil {
 		return err
 	}
-	response, err := client.Do(request)
+	list, err := api.ListApps()
 	if err != nil {
 		return err
 	}
-	if response.StatusCode == http.StatusNoContent {
+	if list == nil && !c.structured() {
 		return nil
 	}
-	defer response.Body.Close()
-	result, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return err
+	apps := make([]app, len(list))
+	for i := range list {
+		apps[i].App = list[i]
 	}
-	return c.Show(result, context)
+	return c.Show(apps, context)
 }
 
-func (c appList) Show(result []byte, context *cmd.Context) error {
-	var apps []app
-	err := json.Unmarshal(result, &apps)
-	if err != nil {
-		return err
+func (c appList) Show(apps []app, context *cmd.Context) error {
+	if c.structured() {
+		names := make([]string, len(apps))
+		for i := range apps {
+			names[i] = apps[i].Name
+		}
+		sort.Strings(names)
+		return c.write(context.Stdout, apps, names)
 	}
 	table := cmd.NewTable()
 	table.Headers = cmd.Row([]string{"Application", "Units State Summary", "Address", "Ready?"})
@@ -495,7 +481,7 @@ func (c appList) Show(result []byte, context *cmd.Context) error {
 func (c appList) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "app-list",
-		Usage: "app-list",
+		Usage: "app-list [--json | --yaml | --format <template> | -q/--quiet]",
 		Desc:  "list all your apps.",
 	}
 }
@@ -504,6 +490,8 @@ type appStop struct {
 	cmd.GuessingCommand
 }
 
+func (*appStop) mutatesTarget() {}
+
 func (c *appStop) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "app-stop",
@@ -520,7 +508,7 @@ func (c *appStop) Run(context *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return err
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/stop", appName))
+	url, err := cmd.GetURL(tsuruClient.Path("/apps/%s/stop", appName))
 	if err != nil {
 		return err
 	}
@@ -544,6 +532,8 @@ type appStart struct {
 	cmd.GuessingCommand
 }
 
+func (*appStart) mutatesTarget() {}
+
 func (c *appStart) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "app-start",
@@ -560,7 +550,7 @@ func (c *appStart) Run(context *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return err
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/start", appName))
+	url, err := cmd.GetURL(tsuruClient.Path("/apps/%s/start", appName))
 	if err != nil {
 		return err
 	}
@@ -584,12 +574,14 @@ type appRestart struct {
 	cmd.GuessingCommand
 }
 
+func (*appRestart) mutatesTarget() {}
+
 func (c *appRestart) Run(context *cmd.Context, client *cmd.Client) error {
 	appName, err := c.Guess()
 	if err != nil {
 		return err
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/restart", appName))
+	url, err := cmd.GetURL(tsuruClient.Path("/apps/%s/restart", appName))
 	if err != nil {
 		return err
 	}
@@ -630,6 +622,8 @@ type cnameAdd struct {
 	cmd.GuessingCommand
 }
 
+func (*cnameAdd) mutatesTarget() {}
+
 func (c *cnameAdd) Run(context *cmd.Context, client *cmd.Client) error {
 	err := addCName(context.Args, c.GuessingCommand, client)
 	if err != nil {
@@ -652,6 +646,8 @@ type cnameRemove struct {
 	cmd.GuessingCommand
 }
 
+func (*cnameRemove) mutatesTarget() {}
+
 func (c *cnameRemove) Run(context *cmd.Context, client *cmd.Client) error {
 	err := unsetCName(context.Args, c.GuessingCommand, client)
 	if err != nil {
@@ -675,7 +671,7 @@ func unsetCName(v []string, g cmd.GuessingCommand, client *cmd.Client) error {
 	if err != nil {
 		return err
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/cname", appName))
+	url, err := cmd.GetURL(tsuruClient.Path("/apps/%s/cname", appName))
 	if err != nil {
 		return err
 	}
@@ -702,7 +698,7 @@ func addCName(v []string, g cmd.GuessingCommand, client *cmd.Client) error {
 	if err != nil {
 		return err
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/cname", appName))
+	url, err := cmd.GetURL(tsuruClient.Path("/apps/%s/cname", appName))
 	if err != nil {
 		return err
 	}
@@ -728,12 +724,14 @@ type SetTeamOwner struct {
 	cmd.GuessingCommand
 }
 
+func (*SetTeamOwner) mutatesTarget() {}
+
 func (c *SetTeamOwner) Run(context *cmd.Context, client *cmd.Client) error {
 	appName, err := c.GuessingCommand.Guess()
 	if err != nil {
 		return err
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/team-owner", appName))
+	url, err := cmd.GetURL(tsuruClient.Path("/apps/%s/team-owner", appName))
 	if err != nil {
 		return err
 	}
@@ -763,6 +761,8 @@ type unitAdd struct {
 	cmd.GuessingCommand
 }
 
+func (*unitAdd) mutatesTarget() {}
+
 func (c *unitAdd) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "unit-add",
@@ -777,7 +777,7 @@ func (c *unitAdd) Run(context *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return err
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/units", appName))
+	url, err := cmd.GetURL(tsuruClient.Path("/apps/%s/units", appName))
 	if err != nil {
 		return err
 	}
@@ -805,23 +805,40 @@ func (c *unitAdd) Run(context *cmd.Context, client *cmd.Client) error {
 
 type unitRemove struct {
 	cmd.GuessingCommand
+	protectedCheck
+	fs *gnuflag.FlagSet
 }
 
+func (*unitRemove) mutatesTarget() {}
+
 func (c *unitRemove) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "unit-remove",
-		Usage:   "unit-remove <# of units> [-a/--app appname]",
+		Usage:   "unit-remove <# of units> [-a/--app appname] [--i-know-this-is-prod]",
 		Desc:    "remove units from an app.",
 		MinArgs: 1,
 	}
 }
 
+func (c *unitRemove) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = cmd.MergeFlagSet(
+			c.GuessingCommand.Flags(),
+			c.protectedCheck.Flags(),
+		)
+	}
+	return c.fs
+}
+
 func (c *unitRemove) Run(context *cmd.Context, client *cmd.Client) error {
 	appName, err := c.Guess()
 	if err != nil {
 		return err
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/units", appName))
+	if ok, err := c.confirmProtected(context, appName); !ok || err != nil {
+		return err
+	}
+	url, err := cmd.GetURL(tsuruClient.Path("/apps/%s/units", appName))
 	if err != nil {
 		return err
 	}
@@ -11,8 +11,10 @@ import (
 	"net/http"
 	"strings"
 
+	tsuruClient "github.com/tsuru/tsuru-client/tsuru/client"
 	"github.com/tsuru/tsuru/cmd"
 	"github.com/tsuru/tsuru/cmd/cmdtest"
+	"github.com/tsuru/tsuru/fs/fstest"
 	"github.com/tsuru/tsuru/io"
 	"launchpad.net/gnuflag"
 	"launchpad.net/gocheck"
@@ -234,6 +236,66 @@ func (s *S) TestAppRemoveWithoutAsking(c *gocheck.C) {
 	c.Assert(stdout.String(), gocheck.Equals, expected)
 }
 
+func (s *S) TestAppRemoveProtected(c *gocheck.C) {
+	fsystem = &fstest.RecordingFs{FileContent: "protected:\n  apps:\n    - ble\n"}
+	defer func() { fsystem = nil }()
+	var stdout, stderr bytes.Buffer
+	expected := `App "ble" is protected. Type its name to confirm: App "ble" successfully removed!` + "\n"
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Stdin:  strings.NewReader("ble\n"),
+	}
+	client := cmd.NewClient(&http.Client{Transport: &cmdtest.Transport{Message: "", Status: http.StatusOK}}, nil, manager)
+	command := appRemove{}
+	command.Flags().Parse(true, []string{"-a", "ble"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestAppRemoveProtectedAssumeYes(c *gocheck.C) {
+	fsystem = &fstest.RecordingFs{FileContent: "protected:\n  apps:\n    - ble\n"}
+	defer func() { fsystem = nil }()
+	var stdout, stderr bytes.Buffer
+	var called bool
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Stdin:  strings.NewReader(""),
+	}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			return true
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := appRemove{}
+	command.Flags().Parse(true, []string{"-a", "ble", "-y"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.ErrorMatches, "Confirmation required for a protected app.*")
+	c.Assert(called, gocheck.Equals, false)
+}
+
+func (s *S) TestAppRemoveProtectedIKnowThisIsProd(c *gocheck.C) {
+	fsystem = &fstest.RecordingFs{FileContent: "protected:\n  apps:\n    - ble\n"}
+	defer func() { fsystem = nil }()
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Stdin:  strings.NewReader(""),
+	}
+	client := cmd.NewClient(&http.Client{Transport: &cmdtest.Transport{Message: "", Status: http.StatusOK}}, nil, manager)
+	command := appRemove{}
+	command.Flags().Parse(true, []string{"-a", "ble", "--i-know-this-is-prod"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, `App "ble" successfully removed!`+"\n")
+}
+
 func (s *S) TestAppRemoveFlags(c *gocheck.C) {
 	command := appRemove{}
 	flagset := command.Flags()
@@ -307,15 +369,32 @@ func (s *S) TestAppRemoveWithoutConfirmation(c *gocheck.C) {
 func (s *S) TestAppRemoveInfo(c *gocheck.C) {
 	expected := &cmd.Info{
 		Name:  "app-remove",
-		Usage: "app-remove [-a/--app appname] [-y/--assume-yes]",
+		Usage: "app-remove [-a/--app appname] [-y/--assume-yes] [--i-know-this-is-prod]",
 		Desc: `removes an app.
 
-If you don't provide the app name, tsuru will try to guess it.`,
+If you don't provide the app name, tsuru will try to guess it.
+
+Protected apps can only be removed after typing their names, or with
+--i-know-this-is-prod.`,
 		MinArgs: 0,
 	}
 	c.Assert((&appRemove{}).Info(), gocheck.DeepEquals, expected)
 }
 
+func (s *S) TestAppInfoNoContent(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	client := cmd.NewClient(&http.Client{Transport: &cmdtest.Transport{Status: http.StatusNoContent}}, nil, manager)
+	command := appInfo{}
+	command.Flags().Parse(true, []string{"--app", "app1"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.ErrorMatches, "App app1 not found.")
+	c.Assert(stdout.String(), gocheck.Equals, "")
+}
+
 func (s *S) TestAppInfo(c *gocheck.C) {
 	var stdout, stderr bytes.Buffer
 	result := `{"name":"app1","teamowner":"myteam","cname":[""],"ip":"myapp.tsuru.io","platform":"php","repository":"git@git.com:php.git","state":"dead", "units":[{"Ip":"10.10.10.10","Name":"app1/0","Status":"started"}, {"Ip":"9.9.9.9","Name":"app1/1","Status":"started"}, {"Ip":"","Name":"app1/2","Status":"pending"}],"teams":["tsuruteam","crane"], "owner": "myapp_owner", "deploys": 7}`
@@ -632,10 +711,13 @@ App Plan:
 func (s *S) TestAppInfoInfo(c *gocheck.C) {
 	expected := &cmd.Info{
 		Name:  "app-info",
-		Usage: "app-info [-a/--app appname]",
+		Usage: "app-info [-a/--app appname] [--json | --yaml | --format <template> | -q/--quiet]",
 		Desc: `show information about your app.
 
-If you don't provide the app name, tsuru will try to guess it.`,
+If you don't provide the app name, tsuru will try to guess it.
+
+With --json, --yaml or --format, the app is printed along with its containers
+and service instances, in the given format.`,
 		MinArgs: 0,
 	}
 	c.Assert((&appInfo{}).Info(), gocheck.DeepEquals, expected)
@@ -892,7 +974,7 @@ func (s *S) TestAppListCName(c *gocheck.C) {
 func (s *S) TestAppListInfo(c *gocheck.C) {
 	expected := &cmd.Info{
 		Name:    "app-list",
-		Usage:   "app-list",
+		Usage:   "app-list [--json | --yaml | --format <template> | -q/--quiet]",
 		Desc:    "list all your apps.",
 		MinArgs: 0,
 	}
@@ -1236,11 +1318,11 @@ func (s *S) TestAppStartIsAFlaggedCommand(c *gocheck.C) {
 }
 
 func (s *S) TestUnitAvailable(c *gocheck.C) {
-	u := &unit{Status: "unreachable"}
+	u := &tsuruClient.Unit{Status: "unreachable"}
 	c.Assert(u.Available(), gocheck.Equals, true)
-	u = &unit{Status: "started"}
+	u = &tsuruClient.Unit{Status: "started"}
 	c.Assert(u.Available(), gocheck.Equals, true)
-	u = &unit{Status: "down"}
+	u = &tsuruClient.Unit{Status: "down"}
 	c.Assert(u.Available(), gocheck.Equals, false)
 }
 
@@ -1419,10 +1501,37 @@ func (s *S) TestUnitRemoveFailure(c *gocheck.C) {
 	c.Assert(err.Error(), gocheck.Equals, "Failed to remove.")
 }
 
+func (s *S) TestUnitRemoveProtectedWrongName(c *gocheck.C) {
+	fsystem = &fstest.RecordingFs{FileContent: "protected:\n  apps:\n    - vapor\n"}
+	defer func() { fsystem = nil }()
+	var stdout, stderr bytes.Buffer
+	var called bool
+	context := cmd.Context{
+		Args:   []string{"2"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Stdin:  strings.NewReader("y\n"),
+	}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			return true
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := unitRemove{}
+	command.Flags().Parse(true, []string{"-a", "vapor"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, false)
+	c.Assert(stdout.String(), gocheck.Equals, `App "vapor" is protected. Type its name to confirm: Abort.`+"\n")
+}
+
 func (s *S) TestUnitRemoveInfo(c *gocheck.C) {
 	expected := cmd.Info{
 		Name:    "unit-remove",
-		Usage:   "unit-remove <# of units> [-a/--app appname]",
+		Usage:   "unit-remove <# of units> [-a/--app appname] [--i-know-this-is-prod]",
 		Desc:    "remove units from an app.",
 		MinArgs: 1,
 	}
@@ -1432,3 +1541,99 @@ func (s *S) TestUnitRemoveInfo(c *gocheck.C) {
 func (s *S) TestUnitRemoveIsACommand(c *gocheck.C) {
 	var _ cmd.Command = &unitRemove{}
 }
+
+func (s *S) TestAppListJSON(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	result := `[{"ip":"10.10.10.10","name":"app1","ready":true,"units":[{"Name":"app1/0","Status":"started"}]}]`
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: &cmdtest.Transport{Message: result, Status: http.StatusOK}}, nil, manager)
+	command := appList{}
+	err := command.Flags().Parse(true, []string{"--json"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	var apps []app
+	err = json.Unmarshal(stdout.Bytes(), &apps)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(apps, gocheck.HasLen, 1)
+	c.Assert(apps[0].Name, gocheck.Equals, "app1")
+	c.Assert(apps[0].Ip, gocheck.Equals, "10.10.10.10")
+	c.Assert(apps[0].Units, gocheck.DeepEquals, []tsuruClient.Unit{{Name: "app1/0", Status: "started"}})
+}
+
+func (s *S) TestAppListJSONWithoutApps(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: &cmdtest.Transport{Status: http.StatusNoContent}}, nil, manager)
+	command := appList{}
+	err := command.Flags().Parse(true, []string{"--json"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "[]\n")
+}
+
+func (s *S) TestAppInfoJSON(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	result := `{"name":"app1","teamowner":"myteam","ip":"myapp.tsuru.io","platform":"php","units":[{"Ip":"10.10.10.10","Name":"app1/0","Status":"started"}],"teams":["tsuruteam"],"deploys":7}`
+	services := `[{"service": "redisapi", "instances": ["myredisapi"]}]`
+	trans := &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: result, Status: http.StatusOK},
+				CondFunc:  func(req *http.Request) bool { return req.URL.Path == "/apps/app1" },
+			},
+			{
+				Transport: cmdtest.Transport{Message: "[]", Status: http.StatusOK},
+				CondFunc:  func(req *http.Request) bool { return req.URL.Path == "/docker/node/apps/app1/containers" },
+			},
+			{
+				Transport: cmdtest.Transport{Message: services, Status: http.StatusOK},
+				CondFunc:  func(req *http.Request) bool { return req.URL.Path == "/services/instances" },
+			},
+		},
+	}
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := appInfo{}
+	err := command.Flags().Parse(true, []string{"--app", "app1", "--json"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	var info map[string]interface{}
+	err = json.Unmarshal(stdout.Bytes(), &info)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(info["Name"], gocheck.Equals, "app1")
+	c.Assert(info["TeamOwner"], gocheck.Equals, "myteam")
+	c.Assert(info["Deploys"], gocheck.Equals, float64(7))
+	c.Assert(info["Containers"], gocheck.DeepEquals, []interface{}{})
+	c.Assert(info["Services"], gocheck.DeepEquals, []interface{}{
+		map[string]interface{}{"Service": "redisapi", "Instances": []interface{}{"myredisapi"}},
+	})
+}
+
+func (s *S) TestAppListFormat(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	result := `[{"ip":"10.10.10.11","name":"sapp","cname":["sapp.example.com"]},{"ip":"10.10.10.10","name":"app1"}]`
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: &cmdtest.Transport{Message: result, Status: http.StatusOK}}, nil, manager)
+	command := appList{}
+	err := command.Flags().Parse(true, []string{"--format", "{{.Name}} {{.Addr}}"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "sapp sapp.example.com, 10.10.10.11\napp1 10.10.10.10\n")
+}
+
+func (s *S) TestAppListQuiet(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	result := `[{"ip":"10.10.10.11","name":"sapp"},{"ip":"10.10.10.10","name":"app1"}]`
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: &cmdtest.Transport{Message: result, Status: http.StatusOK}}, nil, manager)
+	command := appList{}
+	err := command.Flags().Parse(true, []string{"-q"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "app1\nsapp\n")
+}
@@ -15,6 +15,7 @@ import (
 	"os"
 	"sort"
 
+	tsuruClient "github.com/tsuru/tsuru-client/tsuru/client"
 	"github.com/tsuru/tsuru/cmd"
 	"golang.org/x/crypto/ssh/terminal"
 	"launchpad.net/gnuflag"
@@ -22,6 +23,8 @@ import (
 
 type userCreate struct{}
 
+func (*userCreate) mutatesTarget() {}
+
 func (c *userCreate) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "user-create",
@@ -72,6 +75,8 @@ func (c *userCreate) Run(context *cmd.Context, client *cmd.Client) error {
 
 type userRemove struct{}
 
+func (*userRemove) mutatesTarget() {}
+
 func (c *userRemove) Run(context *cmd.Context, client *cmd.Client) error {
 	var answer string
 	fmt.Fprint(context.Stdout, `Are you sure you want to remove your user from tsuru? (y/n) `)
@@ -108,6 +113,8 @@ func (c *userRemove) Info() *cmd.Info {
 
 type teamCreate struct{}
 
+func (*teamCreate) mutatesTarget() {}
+
 func (c *teamCreate) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "team-create",
@@ -140,13 +147,15 @@ type teamRemove struct {
 	cmd.ConfirmationCommand
 }
 
+func (*teamRemove) mutatesTarget() {}
+
 func (c *teamRemove) Run(context *cmd.Context, client *cmd.Client) error {
 	team := context.Args[0]
 	question := fmt.Sprintf("Are you sure you want to remove team %q?", team)
 	if !c.Confirm(context, question) {
 		return nil
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/teams/%s", team))
+	url, err := cmd.GetURL(tsuruClient.Path("/teams/%s", team))
 	if err != nil {
 		return err
 	}
@@ -173,6 +182,8 @@ func (c *teamRemove) Info() *cmd.Info {
 
 type teamUserAdd struct{}
 
+func (*teamUserAdd) mutatesTarget() {}
+
 func (c *teamUserAdd) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "team-user-add",
@@ -184,7 +195,7 @@ func (c *teamUserAdd) Info() *cmd.Info {
 
 func (c *teamUserAdd) Run(context *cmd.Context, client *cmd.Client) error {
 	teamName, userName := context.Args[0], context.Args[1]
-	url, err := cmd.GetURL(fmt.Sprintf("/teams/%s/%s", teamName, userName))
+	url, err := cmd.GetURL(tsuruClient.Path("/teams/%s/%s", teamName, userName))
 	if err != nil {
 		return err
 	}
@@ -202,6 +213,8 @@ func (c *teamUserAdd) Run(context *cmd.Context, client *cmd.Client) error {
 
 type teamUserRemove struct{}
 
+func (*teamUserRemove) mutatesTarget() {}
+
 func (c *teamUserRemove) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "team-user-remove",
@@ -213,7 +226,7 @@ func (c *teamUserRemove) Info() *cmd.Info {
 
 func (c *teamUserRemove) Run(context *cmd.Context, client *cmd.Client) error {
 	teamName, userName := context.Args[0], context.Args[1]
-	url, err := cmd.GetURL(fmt.Sprintf("/teams/%s/%s", teamName, userName))
+	url, err := cmd.GetURL(tsuruClient.Path("/teams/%s/%s", teamName, userName))
 	if err != nil {
 		return err
 	}
@@ -229,11 +242,13 @@ func (c *teamUserRemove) Run(context *cmd.Context, client *cmd.Client) error {
 	return nil
 }
 
-type teamUserList struct{}
+type teamUserList struct {
+	outputCommand
+}
 
-func (teamUserList) Run(context *cmd.Context, client *cmd.Client) error {
+func (c teamUserList) Run(context *cmd.Context, client *cmd.Client) error {
 	teamName := context.Args[0]
-	url, err := cmd.GetURL("/teams/" + teamName)
+	url, err := cmd.GetURL(tsuruClient.Path("/teams/%s", teamName))
 	if err != nil {
 		return err
 	}
@@ -252,6 +267,12 @@ func (teamUserList) Run(context *cmd.Context, client *cmd.Client) error {
 		return err
 	}
 	sort.Strings(t.Users)
+	if c.structured() {
+		if t.Users == nil {
+			t.Users = []string{}
+		}
+		return c.write(context.Stdout, t, t.Users)
+	}
 	for _, user := range t.Users {
 		fmt.Fprintf(context.Stdout, "- %s\n", user)
 	}
@@ -261,18 +282,20 @@ func (teamUserList) Run(context *cmd.Context, client *cmd.Client) error {
 func (teamUserList) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "team-user-list",
-		Usage:   "team-user-list <teamname>",
+		Usage:   "team-user-list <teamname> [--json | --yaml | --format <template> | -q/--quiet]",
 		Desc:    "List members of a team.",
 		MinArgs: 1,
 	}
 }
 
-type teamList struct{}
+type teamList struct {
+	outputCommand
+}
 
 func (c *teamList) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "team-list",
-		Usage:   "team-list",
+		Usage:   "team-list [--json | --yaml | --format <template> | -q/--quiet]",
 		Desc:    "List all teams that you are member.",
 		MinArgs: 0,
 	}
@@ -302,16 +325,27 @@ func (c *teamList) Run(context *cmd.Context, client *cmd.Client) error {
 		if err != nil {
 			return err
 		}
+		if c.structured() {
+			names := make([]string, len(teams))
+			for i, team := range teams {
+				names[i] = team["name"]
+			}
+			return c.write(context.Stdout, teams, names)
+		}
 		io.WriteString(context.Stdout, "Teams:\n\n")
 		for _, team := range teams {
 			fmt.Fprintf(context.Stdout, "  - %s\n", team["name"])
 		}
+	} else if c.structured() {
+		return c.write(context.Stdout, []map[string]string{}, nil)
 	}
 	return nil
 }
 
 type changePassword struct{}
 
+func (*changePassword) mutatesTarget() {}
+
 func (c *changePassword) Run(context *cmd.Context, client *cmd.Client) error {
 	url, err := cmd.GetURL("/users/password")
 	if err != nil {
@@ -369,6 +403,8 @@ type resetPassword struct {
 	token string
 }
 
+func (*resetPassword) mutatesTarget() {}
+
 func (c *resetPassword) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "reset-password",
@@ -401,11 +437,11 @@ Please check your email.`
 }
 
 func (c *resetPassword) Run(context *cmd.Context, client *cmd.Client) error {
-	url := fmt.Sprintf("/users/%s/password", context.Args[0])
+	path := tsuruClient.Path("/users/%s/password", context.Args[0])
 	if c.token != "" {
-		url += "?token=" + c.token
+		path = tsuruClient.Path("/users/%s/password?token=%s", context.Args[0], c.token)
 	}
-	url, err := cmd.GetURL(url)
+	url, err := cmd.GetURL(path)
 	if err != nil {
 		return err
 	}
@@ -487,6 +523,8 @@ func (c *showAPIToken) Run(context *cmd.Context, client *cmd.Client) error {
 
 type regenerateAPIToken struct{}
 
+func (*regenerateAPIToken) mutatesTarget() {}
+
 func (c *regenerateAPIToken) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "token-regenerate",
@@ -202,7 +202,7 @@ func (s *S) TestTeamUserListError(c *gocheck.C) {
 func (s *S) TestTeamUserListInfo(c *gocheck.C) {
 	expected := &cmd.Info{
 		Name:    "team-user-list",
-		Usage:   "team-user-list <teamname>",
+		Usage:   "team-user-list <teamname> [--json | --yaml | --format <template> | -q/--quiet]",
 		Desc:    "List members of a team.",
 		MinArgs: 1,
 	}
@@ -256,7 +256,7 @@ func (s *S) TestTeamListRunWithNoContent(c *gocheck.C) {
 func (s *S) TestTeamListInfo(c *gocheck.C) {
 	expected := &cmd.Info{
 		Name:    "team-list",
-		Usage:   "team-list",
+		Usage:   "team-list [--json | --yaml | --format <template> | -q/--quiet]",
 		Desc:    "List all teams that you are member.",
 		MinArgs: 0,
 	}
@@ -593,6 +593,25 @@ Please check your email.` + "\n"
 	c.Assert(called, gocheck.Equals, true)
 }
 
+func (s *S) TestResetPasswordEscapesEmailAndToken(c *gocheck.C) {
+	var called bool
+	context := cmd.Context{Args: []string{"me+tsuru@example.com"}, Stdout: &bytes.Buffer{}}
+	trans := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Status: http.StatusOK, Message: ""},
+		CondFunc: func(r *http.Request) bool {
+			called = true
+			return r.URL.Path == "/users/me+tsuru@example.com/password" &&
+				r.URL.Query().Get("token") == "a/b+c"
+		},
+	}
+	command := resetPassword{}
+	command.Flags().Parse(true, []string{"-t", "a/b+c"})
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+}
+
 func (s *S) TestResetPasswordInfo(c *gocheck.C) {
 	expected := &cmd.Info{
 		Name:  "reset-password",
@@ -740,3 +759,32 @@ func (s *S) TestRegenerateAPITokenInfo(c *gocheck.C) {
 func (s *S) TestTRegenerateAPITokenIsACommand(c *gocheck.C) {
 	var _ cmd.Command = &regenerateAPIToken{}
 }
+
+func (s *S) TestTeamListJSON(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	result := `[{"name":"timeredbull"},{"name":"cruzeiro"}]`
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: &cmdtest.Transport{Message: result, Status: http.StatusOK}}, nil, manager)
+	command := teamList{}
+	err := command.Flags().Parse(true, []string{"--json"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	var teams []map[string]string
+	err = json.Unmarshal(stdout.Bytes(), &teams)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(teams, gocheck.DeepEquals, []map[string]string{{"name": "timeredbull"}, {"name": "cruzeiro"}})
+}
+
+func (s *S) TestTeamUserListYAML(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	result := `{"name":"symfonia","users":["somebody@tsuru.io","otherbody@tsuru.io"]}`
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr, Args: []string{"symfonia"}}
+	client := cmd.NewClient(&http.Client{Transport: &cmdtest.Transport{Message: result, Status: http.StatusOK}}, nil, manager)
+	command := teamUserList{}
+	err := command.Flags().Parse(true, []string{"--yaml"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "Users:\n- otherbody@tsuru.io\n- somebody@tsuru.io\n")
+}
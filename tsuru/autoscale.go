@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"time"
 
+	tsuruClient "github.com/tsuru/tsuru-client/tsuru/client"
 	"github.com/tsuru/tsuru/cmd"
 	"launchpad.net/gnuflag"
 )
@@ -19,6 +20,8 @@ type autoScaleEnable struct {
 	cmd.GuessingCommand
 }
 
+func (*autoScaleEnable) mutatesTarget() {}
+
 func (c *autoScaleEnable) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "autoscale-enable",
@@ -32,7 +35,7 @@ func (c *autoScaleEnable) Run(context *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return err
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/autoscale/%s/enable", appName))
+	url, err := cmd.GetURL(tsuruClient.Path("/autoscale/%s/enable", appName))
 	if err != nil {
 		return err
 	}
@@ -52,6 +55,8 @@ type autoScaleDisable struct {
 	cmd.GuessingCommand
 }
 
+func (*autoScaleDisable) mutatesTarget() {}
+
 func (c *autoScaleDisable) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "autoscale-disable",
@@ -65,7 +70,7 @@ func (c *autoScaleDisable) Run(context *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return err
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/autoscale/%s/disable", appName))
+	url, err := cmd.GetURL(tsuruClient.Path("/autoscale/%s/disable", appName))
 	if err != nil {
 		return err
 	}
@@ -95,6 +100,8 @@ type autoScaleConfig struct {
 	enabled            bool
 }
 
+func (*autoScaleConfig) mutatesTarget() {}
+
 func (c *autoScaleConfig) Flags() *gnuflag.FlagSet {
 	if c.fs == nil {
 		c.fs = c.GuessingCommand.Flags()
@@ -154,7 +161,7 @@ func (c *autoScaleConfig) Run(context *cmd.Context, client *cmd.Client) error {
 			Units:      c.decreaseStep,
 		},
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/autoscale/%s", appName))
+	url, err := cmd.GetURL(tsuruClient.Path("/autoscale/%s", appName))
 	if err != nil {
 		return err
 	}
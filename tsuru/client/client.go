@@ -0,0 +1,306 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package client is a typed client for part of the tsuru API: apps, plans,
+// environment variables, service binds, deploys and rollbacks. It's used by
+// the commands of the tsuru command line that deal with these, and available
+// to other programs that talk to tsuru. The remaining commands still send
+// their requests directly, building their paths with Path.
+//
+// Error statuses of the API are returned as *errors.HTTP, from the package
+// github.com/tsuru/tsuru/errors, with the status code and the message of the
+// server.
+//
+// Operations that the server streams while they run, like deploys, return a
+// Stream with the output of the operation, which the caller must close.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	tsuruapp "github.com/tsuru/tsuru/app"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+)
+
+// JSONStreamContentType is the content type of streams made of JSON
+// messages, one per line.
+const JSONStreamContentType = "application/x-json-stream"
+
+// Doer sends HTTP requests. *http.Client is a Doer, and so is the client of
+// the tsuru command line.
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// Client talks to the API of a tsuru server.
+type Client struct {
+	target string
+	token  string
+	doer   Doer
+}
+
+// New returns a client for the tsuru server in target, like
+// https://tsuru.example.com, which sends its requests with doer. When token
+// isn't empty, it's sent with every request.
+func New(target, token string, doer Doer) *Client {
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		target = "http://" + target
+	}
+	if doer == nil {
+		doer = http.DefaultClient
+	}
+	return &Client{target: strings.TrimRight(target, "/"), token: token, doer: doer}
+}
+
+// IsNotFound tells whether err is an error status 404 returned by the API.
+func IsNotFound(err error) bool {
+	e, ok := err.(*tsuruErrors.HTTP)
+	return ok && e.Code == http.StatusNotFound
+}
+
+// Stream is the output of an operation, streamed by the server while the
+// operation runs.
+type Stream struct {
+	io.ReadCloser
+	// ContentType tells the format of the output: JSONStreamContentType
+	// for streams of JSON messages, or plain text otherwise.
+	ContentType string
+}
+
+// Unit is a unit of an app.
+type Unit struct {
+	Name   string
+	Ip     string
+	Status string
+}
+
+// Available tells whether the unit is able to serve requests.
+func (u *Unit) Available() bool {
+	return u.Status == "started" || u.Status == "unreachable"
+}
+
+// App is an app, as listed by the API.
+type App struct {
+	Ip         string
+	CName      []string
+	Name       string
+	Platform   string
+	Repository string
+	Teams      []string
+	Units      []Unit
+	Ready      bool
+	Owner      string
+	TeamOwner  string
+	Deploys    uint
+	Plan       tsuruapp.Plan
+}
+
+// Addr returns the addresses of the app, its CNames first.
+func (a *App) Addr() string {
+	cnames := strings.Join(a.CName, ", ")
+	if cnames != "" {
+		return fmt.Sprintf("%s, %s", cnames, a.Ip)
+	}
+	return a.Ip
+}
+
+// IsReady returns Yes or No, telling whether the app is ready.
+func (a *App) IsReady() string {
+	if a.Ready {
+		return "Yes"
+	}
+	return "No"
+}
+
+// GetTeams returns the teams that have access to the app, comma separated.
+func (a *App) GetTeams() string {
+	return strings.Join(a.Teams, ", ")
+}
+
+// ListApps returns the apps the user has access to.
+func (c *Client) ListApps() ([]App, error) {
+	var apps []App
+	err := c.get("/apps", &apps)
+	return apps, err
+}
+
+// GetApp returns the app with the given name. An app the server has no
+// content for is not found.
+func (c *Client) GetApp(name string) (*App, error) {
+	var app *App
+	err := c.get(Path("/apps/%s", name), &app)
+	if err != nil {
+		return nil, err
+	}
+	if app == nil {
+		return nil, &tsuruErrors.HTTP{Code: http.StatusNotFound, Message: fmt.Sprintf("App %s not found.", name)}
+	}
+	return app, nil
+}
+
+// SetEnv sets environment variables of the app, which is restarted with
+// them.
+func (c *Client) SetEnv(app string, envs map[string]string) (*Stream, error) {
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(envs)
+	if err != nil {
+		return nil, err
+	}
+	return c.stream("POST", Path("/apps/%s/env", app), "", &buf)
+}
+
+// Deploy deploys the app with the given gzipped tar archive. The archive is
+// sent while it's read, so it may be generated as it's uploaded.
+func (c *Client) Deploy(app string, archive io.Reader) (*Stream, error) {
+	body, bodyWriter := io.Pipe()
+	writer := multipart.NewWriter(bodyWriter)
+	go func() {
+		file, err := writer.CreateFormFile("file", "archive.tar.gz")
+		if err == nil {
+			_, err = io.Copy(file, archive)
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		bodyWriter.CloseWithError(err)
+	}()
+	request, err := c.newRequest("POST", Path("/apps/%s/deploy", app), body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	// The size of the archive is unknown, so the request uses chunked
+	// transfer encoding.
+	request.ContentLength = -1
+	stream, err := c.send(request)
+	body.Close()
+	return stream, err
+}
+
+// DeployImage deploys the app with a Docker image, which must be available
+// in a registry reachable by the tsuru nodes.
+func (c *Client) DeployImage(app, image string) (*Stream, error) {
+	return c.postForm(Path("/apps/%s/deploy", app), url.Values{"image": {image}})
+}
+
+// Rollback deploys the app with the image of one of its previous deploys.
+func (c *Client) Rollback(app, image string) (*Stream, error) {
+	return c.postForm(Path("/apps/%s/deploy/rollback", app), url.Values{"image": {image}})
+}
+
+// BindService binds the service instance to the app.
+func (c *Client) BindService(instance, app string) (*Stream, error) {
+	return c.stream("PUT", Path("/services/instances/%s/%s", instance, app), "", nil)
+}
+
+// ListPlans returns the plans available to apps.
+func (c *Client) ListPlans() ([]tsuruapp.Plan, error) {
+	var plans []tsuruapp.Plan
+	err := c.get("/plans", &plans)
+	return plans, err
+}
+
+// ListDeploys returns deploys of the app, or of all apps when app is empty,
+// from the newest to the oldest.
+func (c *Client) ListDeploys(app string, skip, limit int) ([]tsuruapp.DeployData, error) {
+	query := url.Values{"limit": {strconv.Itoa(limit)}, "skip": {strconv.Itoa(skip)}}
+	if app != "" {
+		query.Set("app", app)
+	}
+	var deploys []tsuruapp.DeployData
+	err := c.get("/deploys?"+query.Encode(), &deploys)
+	return deploys, err
+}
+
+// GetDeploy returns the deploy with the given ID, along with its log.
+func (c *Client) GetDeploy(id string) (*tsuruapp.DeployData, error) {
+	var deploy tsuruapp.DeployData
+	err := c.get(Path("/deploys/%s", id), &deploy)
+	if err != nil {
+		return nil, err
+	}
+	return &deploy, nil
+}
+
+func (c *Client) newRequest(method, path string, body io.Reader) (*http.Request, error) {
+	request, err := http.NewRequest(method, c.target+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		request.Header.Set("Authorization", "bearer "+c.token)
+	}
+	return request, nil
+}
+
+// do sends the request. Error statuses are returned as *errors.HTTP, from
+// github.com/tsuru/tsuru/errors, like the client of the command line already
+// does. Other errors returned by the Doer are returned unchanged.
+func (c *Client) do(request *http.Request) (*http.Response, error) {
+	response, err := c.doer.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= http.StatusBadRequest {
+		defer response.Body.Close()
+		message, _ := ioutil.ReadAll(response.Body)
+		return nil, &tsuruErrors.HTTP{Code: response.StatusCode, Message: string(message)}
+	}
+	return response, nil
+}
+
+// get decodes the JSON document in path into v, which is left untouched when
+// the server has no content to return.
+func (c *Client) get(path string, v interface{}) error {
+	request, err := c.newRequest("GET", path, nil)
+	if err != nil {
+		return err
+	}
+	response, err := c.do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	result, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(result, v)
+}
+
+func (c *Client) stream(method, path, contentType string, body io.Reader) (*Stream, error) {
+	request, err := c.newRequest(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	return c.send(request)
+}
+
+func (c *Client) postForm(path string, values url.Values) (*Stream, error) {
+	return c.stream("POST", path, "application/x-www-form-urlencoded", strings.NewReader(values.Encode()))
+}
+
+func (c *Client) send(request *http.Request) (*Stream, error) {
+	response, err := c.do(request)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{ReadCloser: response.Body, ContentType: response.Header.Get("Content-Type")}, nil
+}
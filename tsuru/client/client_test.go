@@ -0,0 +1,260 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/cmd/cmdtest"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"launchpad.net/gocheck"
+)
+
+type S struct{}
+
+var _ = gocheck.Suite(&S{})
+
+func Test(t *testing.T) { gocheck.TestingT(t) }
+
+func newClient(transport http.RoundTripper) *Client {
+	return New("tsuru.example.com/", "sometoken", &http.Client{Transport: transport})
+}
+
+type failingDoer struct{}
+
+func (failingDoer) Do(*http.Request) (*http.Response, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (s *S) TestNewNormalizesTarget(c *gocheck.C) {
+	client := New("tsuru.example.com/", "", nil)
+	c.Assert(client.target, gocheck.Equals, "http://tsuru.example.com")
+	client = New("https://tsuru.example.com", "", nil)
+	c.Assert(client.target, gocheck.Equals, "https://tsuru.example.com")
+	c.Assert(client.doer, gocheck.Equals, http.DefaultClient)
+}
+
+func (s *S) TestListApps(c *gocheck.C) {
+	result := `[{"name":"app1","ip":"app1.example.com","ready":true,"units":[{"Name":"app1/0","Status":"started"}]}]`
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: result, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.String() == "http://tsuru.example.com/apps" && req.Method == "GET" &&
+				req.Header.Get("Authorization") == "bearer sometoken"
+		},
+	}
+	apps, err := newClient(trans).ListApps()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(apps, gocheck.HasLen, 1)
+	c.Assert(apps[0].Name, gocheck.Equals, "app1")
+	c.Assert(apps[0].Addr(), gocheck.Equals, "app1.example.com")
+	c.Assert(apps[0].IsReady(), gocheck.Equals, "Yes")
+	c.Assert(apps[0].Units, gocheck.DeepEquals, []Unit{{Name: "app1/0", Status: "started"}})
+}
+
+func (s *S) TestListAppsNoContent(c *gocheck.C) {
+	trans := &cmdtest.Transport{Status: http.StatusNoContent}
+	apps, err := newClient(trans).ListApps()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(apps, gocheck.IsNil)
+}
+
+func (s *S) TestGetApp(c *gocheck.C) {
+	result := `{"name":"app1","cname":["app1.io"],"ip":"app1.example.com","teams":["admin","ops"],"plan":{"name":"small"}}`
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: result, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/apps/app1" && req.Method == "GET"
+		},
+	}
+	app, err := newClient(trans).GetApp("app1")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(app.Name, gocheck.Equals, "app1")
+	c.Assert(app.Addr(), gocheck.Equals, "app1.io, app1.example.com")
+	c.Assert(app.GetTeams(), gocheck.Equals, "admin, ops")
+	c.Assert(app.Plan.Name, gocheck.Equals, "small")
+}
+
+func (s *S) TestGetAppNotFound(c *gocheck.C) {
+	trans := &cmdtest.Transport{Message: "App not found", Status: http.StatusNotFound}
+	app, err := newClient(trans).GetApp("app1")
+	c.Assert(app, gocheck.IsNil)
+	c.Assert(err, gocheck.DeepEquals, &tsuruErrors.HTTP{Code: http.StatusNotFound, Message: "App not found"})
+	c.Assert(err, gocheck.ErrorMatches, "App not found")
+	c.Assert(IsNotFound(err), gocheck.Equals, true)
+}
+
+func (s *S) TestGetAppNoContent(c *gocheck.C) {
+	trans := &cmdtest.Transport{Status: http.StatusNoContent}
+	app, err := newClient(trans).GetApp("app1")
+	c.Assert(app, gocheck.IsNil)
+	c.Assert(err, gocheck.ErrorMatches, "App app1 not found.")
+	c.Assert(IsNotFound(err), gocheck.Equals, true)
+}
+
+func (s *S) TestErrorsFromCommandLineClient(c *gocheck.C) {
+	trans := &cmdtest.Transport{Message: "App not found", Status: http.StatusNotFound}
+	doer := cmd.NewClient(&http.Client{Transport: trans}, nil, cmd.NewManager("tsuru", "0.1", "", nil, nil, nil, nil))
+	_, err := New("tsuru.example.com", "", doer).GetApp("app1")
+	c.Assert(err, gocheck.DeepEquals, &tsuruErrors.HTTP{Code: http.StatusNotFound, Message: "App not found"})
+	c.Assert(IsNotFound(err), gocheck.Equals, true)
+}
+
+func (s *S) TestDoerErrorsAreReturnedUnchanged(c *gocheck.C) {
+	client := New("tsuru.example.com", "", failingDoer{})
+	_, err := client.ListApps()
+	c.Assert(err, gocheck.ErrorMatches, "connection refused")
+	c.Assert(IsNotFound(err), gocheck.Equals, false)
+}
+
+func (s *S) TestSetEnv(c *gocheck.C) {
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{
+			Message: `{"Message":"restarting"}` + "\n",
+			Status:  http.StatusOK,
+			Headers: map[string][]string{"Content-Type": {JSONStreamContentType}},
+		},
+		CondFunc: func(req *http.Request) bool {
+			var envs map[string]string
+			err := json.NewDecoder(req.Body).Decode(&envs)
+			return err == nil && req.URL.Path == "/apps/app1/env" && req.Method == "POST" &&
+				envs["DATABASE_HOST"] == "localhost"
+		},
+	}
+	stream, err := newClient(trans).SetEnv("app1", map[string]string{"DATABASE_HOST": "localhost"})
+	c.Assert(err, gocheck.IsNil)
+	defer stream.Close()
+	c.Assert(stream.ContentType, gocheck.Equals, JSONStreamContentType)
+	output, err := ioutil.ReadAll(stream)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(string(output), gocheck.Equals, `{"Message":"restarting"}`+"\n")
+}
+
+func (s *S) TestDeploy(c *gocheck.C) {
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "deployed\nOK\n", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			file, header, err := req.FormFile("file")
+			if err != nil {
+				return false
+			}
+			defer file.Close()
+			content, err := ioutil.ReadAll(file)
+			return err == nil && string(content) == "archive content" &&
+				header.Filename == "archive.tar.gz" && req.URL.Path == "/apps/app1/deploy" &&
+				req.Method == "POST"
+		},
+	}
+	stream, err := newClient(trans).Deploy("app1", strings.NewReader("archive content"))
+	c.Assert(err, gocheck.IsNil)
+	defer stream.Close()
+	output, err := ioutil.ReadAll(stream)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(string(output), gocheck.Equals, "deployed\nOK\n")
+}
+
+func (s *S) TestDeployImage(c *gocheck.C) {
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "deployed", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/apps/app1/deploy" && req.Method == "POST" &&
+				req.FormValue("image") == "registry.example.com/app1:v1"
+		},
+	}
+	stream, err := newClient(trans).DeployImage("app1", "registry.example.com/app1:v1")
+	c.Assert(err, gocheck.IsNil)
+	stream.Close()
+}
+
+func (s *S) TestRollback(c *gocheck.C) {
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "rolled back", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/apps/app1/deploy/rollback" && req.Method == "POST" &&
+				req.FormValue("image") == "tsuru/app-app1:v2"
+		},
+	}
+	stream, err := newClient(trans).Rollback("app1", "tsuru/app-app1:v2")
+	c.Assert(err, gocheck.IsNil)
+	stream.Close()
+}
+
+func (s *S) TestRollbackFailure(c *gocheck.C) {
+	trans := &cmdtest.Transport{Message: "image not found", Status: http.StatusBadRequest}
+	stream, err := newClient(trans).Rollback("app1", "tsuru/app-app1:v2")
+	c.Assert(stream, gocheck.IsNil)
+	c.Assert(err, gocheck.DeepEquals, &tsuruErrors.HTTP{Code: http.StatusBadRequest, Message: "image not found"})
+}
+
+func (s *S) TestBindService(c *gocheck.C) {
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "bound", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/services/instances/mysql/app1" && req.Method == "PUT"
+		},
+	}
+	stream, err := newClient(trans).BindService("mysql", "app1")
+	c.Assert(err, gocheck.IsNil)
+	stream.Close()
+}
+
+func (s *S) TestListPlans(c *gocheck.C) {
+	result := `[{"name":"small","memory":134217728,"swap":268435456,"cpushare":100,"default":true}]`
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: result, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/plans" && req.Method == "GET"
+		},
+	}
+	plans, err := newClient(trans).ListPlans()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(plans, gocheck.HasLen, 1)
+	c.Assert(plans[0].Name, gocheck.Equals, "small")
+	c.Assert(plans[0].Memory, gocheck.Equals, int64(134217728))
+	c.Assert(plans[0].Default, gocheck.Equals, true)
+}
+
+func (s *S) TestListDeploys(c *gocheck.C) {
+	result := `[{"ID":"54c92d91a46ec0e78501d86b","App":"app1","Image":"tsuru/app-app1:v2"}]`
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: result, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			query := req.URL.Query()
+			return req.URL.Path == "/deploys" && query.Get("limit") == "10" && query.Get("skip") == "20" &&
+				query.Get("app") == "app1"
+		},
+	}
+	deploys, err := newClient(trans).ListDeploys("app1", 20, 10)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(deploys, gocheck.HasLen, 1)
+	c.Assert(deploys[0].ID.Hex(), gocheck.Equals, "54c92d91a46ec0e78501d86b")
+	c.Assert(deploys[0].Image, gocheck.Equals, "tsuru/app-app1:v2")
+}
+
+func (s *S) TestGetDeploy(c *gocheck.C) {
+	result := `{"ID":"54c92d91a46ec0e78501d86b","App":"app1","Log":"building"}`
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: result, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/deploys/54c92d91a46ec0e78501d86b"
+		},
+	}
+	deploy, err := newClient(trans).GetDeploy("54c92d91a46ec0e78501d86b")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(deploy.App, gocheck.Equals, "app1")
+	c.Assert(deploy.Log, gocheck.Equals, "building")
+}
+
+func (s *S) TestUnitAvailable(c *gocheck.C) {
+	c.Assert((&Unit{Status: "started"}).Available(), gocheck.Equals, true)
+	c.Assert((&Unit{Status: "unreachable"}).Available(), gocheck.Equals, true)
+	c.Assert((&Unit{Status: "down"}).Available(), gocheck.Equals, false)
+}
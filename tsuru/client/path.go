@@ -0,0 +1,48 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Path formats a path of the API like fmt.Sprintf, escaping the string
+// arguments: the ones before the "?" of the format are escaped as path
+// segments, and the others as query values. So names with slashes, spaces,
+// plus signs or other reserved characters always end up in the segment or
+// value they were given for. Other arguments are formatted unchanged.
+//
+// The format must only use verbs without flags, like %s, %d and %t.
+func Path(format string, args ...interface{}) string {
+	query := strings.Index(format, "?")
+	escaped := make([]interface{}, len(args))
+	copy(escaped, args)
+	var n int
+	for i := 0; i < len(format)-1 && n < len(args); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		if format[i] == '%' {
+			continue
+		}
+		if s, ok := args[n].(string); ok {
+			if query >= 0 && i > query {
+				escaped[n] = url.QueryEscape(s)
+			} else {
+				escaped[n] = escapeSegment(s)
+			}
+		}
+		n++
+	}
+	return fmt.Sprintf(format, escaped...)
+}
+
+// escapeSegment escapes s to be used as a single segment of a path.
+func escapeSegment(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
@@ -0,0 +1,55 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/tsuru/tsuru/cmd/cmdtest"
+	"launchpad.net/gocheck"
+)
+
+func (s *S) TestPath(c *gocheck.C) {
+	var tests = []struct {
+		format string
+		args   []interface{}
+		want   string
+	}{
+		{"/apps", nil, "/apps"},
+		{"/apps/%s/env", []interface{}{"myapp"}, "/apps/myapp/env"},
+		{"/teams/%s/%s", []interface{}{"ops", "me+tsuru@example.com"}, "/teams/ops/me%2Btsuru%40example.com"},
+		{"/services/instances/%s/%s", []interface{}{"my db", "a/b"}, "/services/instances/my%20db/a%2Fb"},
+		{"/apps/%s/log?lines=%d&source=%s", []interface{}{"myapp", 10, "my source+1"}, "/apps/myapp/log?lines=10&source=my+source%2B1"},
+		{"/swap?app1=%s&app2=%s&force=%t", []interface{}{"a&b", "c=d", true}, "/swap?app1=a%26b&app2=c%3Dd&force=true"},
+		{"/users/%s/password?token=%s", []interface{}{"me@example.com", "a/b+c"}, "/users/me%40example.com/password?token=a%2Fb%2Bc"},
+		{"/apps/%s/units?%%s", []interface{}{"my app"}, "/apps/my%20app/units?%s"},
+		{"/apps/%s/%s", []interface{}{"..", "?x=1"}, "/apps/../%3Fx%3D1"},
+	}
+	for _, t := range tests {
+		c.Check(Path(t.format, t.args...), gocheck.Equals, t.want, gocheck.Commentf("format: %q", t.format))
+	}
+}
+
+func (s *S) TestPathRoundTrip(c *gocheck.C) {
+	path := Path("/teams/%s/%s?token=%s", "my team", "a/b+c@example.com", "x&y=z +")
+	u, err := url.Parse("http://tsuru.example.com" + path)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(u.Path, gocheck.Equals, "/teams/my team/a/b+c@example.com")
+	c.Assert(u.EscapedPath(), gocheck.Equals, "/teams/my%20team/a%2Fb%2Bc%40example.com")
+	c.Assert(u.Query().Get("token"), gocheck.Equals, "x&y=z +")
+}
+
+func (s *S) TestClientEscapesNames(c *gocheck.C) {
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "bound", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.EscapedPath() == "/services/instances/my%20db%2Fprod/app%2B1"
+		},
+	}
+	stream, err := newClient(trans).BindService("my db/prod", "app+1")
+	c.Assert(err, gocheck.IsNil)
+	stream.Close()
+}
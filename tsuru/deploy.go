@@ -8,84 +8,161 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
-	"mime/multipart"
 	"net/http"
 	"os"
+	pathpkg "path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	tsuruClient "github.com/tsuru/tsuru-client/tsuru/client"
 	tsuruapp "github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/cmd"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
 	tsuruIo "github.com/tsuru/tsuru/io"
+	"gopkg.in/mgo.v2/bson"
 	"launchpad.net/gnuflag"
 )
 
 type appDeployList struct {
 	cmd.GuessingCommand
+	outputCommand
+	fs      *gnuflag.FlagSet
+	limit   int
+	skip    int
+	page    int
+	user    string
+	origin  string
+	status  string
+	since   timeFlag
+	until   timeFlag
+	allApps bool
 }
 
 func (c *appDeployList) Info() *cmd.Info {
+	desc := `List information about deploys for an application, from the newest to the
+oldest.
+
+By default, the last 10 deploys are listed. Use --limit to change the number
+of deploys, and --page or --skip to see older ones. Deploys may be filtered by
+the user who made them, by their origin (git, rollback, upload or image), by
+their date, with --since and --until, and by their status (success or
+failure). Dates are given like 2015-01-28 or 2015-01-28 15:04, or as a
+duration before now, like 12h or 7d. As the filters are applied by the
+client, only the newest 2000 deploys are searched.
+
+Use --all-apps to list the deploys of all apps you have access to. To be read
+by scripts, deploys may be printed with --json, --yaml, or --format, which
+takes a Go template, like '{{.Image}} {{.User}}'. --quiet prints only their
+IDs.
+`
 	return &cmd.Info{
 		Name:  "app-deploy-list",
-		Usage: "app-deploy-list [-a/--app <appname>]",
-		Desc:  "List information about deploys for an application.",
+		Usage: "app-deploy-list [-a/--app <appname> | --all-apps] [-l/--limit <n>] [--page <n> | --skip <n>] [--user <email>] [--origin <origin>] [--since <date>] [--until <date>] [--status <success|failure>] [--json | --yaml | --format <template> | -q/--quiet]",
+		Desc:  desc,
+	}
+}
+
+func (c *appDeployList) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = c.GuessingCommand.Flags()
+		limit := "Maximum number of deploys to list"
+		c.fs.IntVar(&c.limit, "limit", 10, limit)
+		c.fs.IntVar(&c.limit, "l", 10, limit)
+		c.fs.IntVar(&c.skip, "skip", 0, "Number of deploys to skip, from the newest")
+		c.fs.IntVar(&c.page, "page", 0, "Page of deploys to list, starting at 1")
+		c.fs.StringVar(&c.user, "user", "", "List only deploys made by the given user")
+		c.fs.StringVar(&c.origin, "origin", "", "List only deploys with the given origin: git, rollback, upload or image")
+		c.fs.StringVar(&c.status, "status", "", "List only successful (success) or failed (failure) deploys")
+		c.fs.Var(&c.since, "since", "List only deploys made after the given date")
+		c.fs.Var(&c.until, "until", "List only deploys made before the given date")
+		c.fs.BoolVar(&c.allApps, "all-apps", false, "List deploys of all apps")
+		c.fs = cmd.MergeFlagSet(c.fs, c.outputCommand.Flags())
 	}
+	return c.fs
 }
 
 func (c *appDeployList) Run(context *cmd.Context, client *cmd.Client) error {
-	appName, err := c.Guess()
-	if err != nil {
-		return err
+	var appName string
+	if !c.allApps {
+		var err error
+		appName, err = c.Guess()
+		if err != nil {
+			return err
+		}
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/deploys?app=%s&limit=10", appName))
-	if err != nil {
-		return err
+	if c.status != "" && c.status != "success" && c.status != "failure" {
+		return fmt.Errorf("invalid status %q, it must be success or failure", c.status)
 	}
-	request, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return err
+	limit := c.limit
+	if limit <= 0 {
+		limit = 10
 	}
-	response, err := client.Do(request)
+	skip := c.skip
+	if c.page > 0 {
+		if skip > 0 {
+			return errors.New("You can't use --page and --skip together.")
+		}
+		skip = (c.page - 1) * limit
+	}
+	// One more deploy is fetched to tell whether there are older ones.
+	deploys, truncated, err := c.fetch(client, appName, skip, limit+1)
 	if err != nil {
 		return err
 	}
-	if response.StatusCode == http.StatusNoContent {
-		return nil
+	if truncated {
+		fmt.Fprintf(context.Stderr, "Only the newest %d deploys were searched, older ones weren't checked.\n",
+			maxDeployListBatches*deployListBatchSize)
 	}
-	defer response.Body.Close()
-	result, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return err
+	more := len(deploys) > limit
+	if more {
+		deploys = deploys[:limit]
 	}
-	var deploys []tsuruapp.DeployData
-	err = json.Unmarshal(result, &deploys)
-	if err != nil {
-		return err
+	if c.structured() {
+		if deploys == nil {
+			deploys = []tsuruapp.DeployData{}
+		}
+		ids := make([]string, len(deploys))
+		for i := range deploys {
+			ids[i] = deploys[i].ID.Hex()
+		}
+		return c.write(context.Stdout, deploys, ids)
+	}
+	if len(deploys) == 0 {
+		return nil
 	}
 	table := cmd.NewTable()
-	table.Headers = cmd.Row([]string{"Image (Rollback)", "Origin", "User", "Date (Duration)", "Error"})
+	headers := []string{"Image (Rollback)", "Origin", "User", "Date (Duration)", "Error"}
+	if c.allApps {
+		headers = append([]string{"App"}, headers...)
+	}
+	table.Headers = cmd.Row(headers)
 	for _, deploy := range deploys {
 		timestamp := deploy.Timestamp.Local().Format(time.Stamp)
-		seconds := deploy.Duration / time.Second
-		minutes := seconds / 60
-		seconds = seconds % 60
 		if deploy.Origin == "git" {
 			if len(deploy.Commit) > 7 {
 				deploy.Commit = deploy.Commit[:7]
 			}
 			deploy.Origin = fmt.Sprintf("git (%s)", deploy.Commit)
 		}
-		timestamp = fmt.Sprintf("%s (%02d:%02d)", timestamp, minutes, seconds)
+		timestamp = fmt.Sprintf("%s (%s)", timestamp, deployDuration(deploy.Duration))
 		if deploy.CanRollback {
 			deploy.Image += " (*)"
 		}
 		rowData := []string{deploy.Image, deploy.Origin, deploy.User, timestamp, deploy.Error}
+		if c.allApps {
+			rowData = append([]string{deploy.App}, rowData...)
+		}
 		if deploy.Error != "" {
 			for i, el := range rowData {
 				if el != "" {
@@ -97,136 +174,895 @@ func (c *appDeployList) Run(context *cmd.Context, client *cmd.Client) error {
 		table.AddRow(cmd.Row(rowData))
 	}
 	context.Stdout.Write(table.Bytes())
+	if more {
+		if skip%limit == 0 {
+			fmt.Fprintf(context.Stdout, "Use --page %d to see older deploys.\n", skip/limit+2)
+		} else {
+			fmt.Fprintf(context.Stdout, "Use --skip %d to see older deploys.\n", skip+limit)
+		}
+	}
 	return nil
 }
 
+// deployListBatchSize is the number of deploys requested at once when
+// filtering deploys.
+const deployListBatchSize = 100
+
+// maxDeployListBatches is the number of batches of deploys scanned before a
+// search with filters gives up, so filters matching only old deploys, or none
+// at all, don't request the whole history of the app.
+var maxDeployListBatches = 20
+
+// fetch returns up to limit deploys matching the filters of the command,
+// skipping the newest ones. The server doesn't filter deploys, so when
+// filters are used, deploys are requested in batches and filtered here, up to
+// maxDeployListBatches batches. It also reports whether the search stopped
+// before reaching the oldest deploys that could match.
+func (c *appDeployList) fetch(client *cmd.Client, appName string, skip, limit int) ([]tsuruapp.DeployData, bool, error) {
+	if !c.filtering() {
+		deploys, err := listDeploys(client, appName, skip, limit)
+		return deploys, false, err
+	}
+	var result []tsuruapp.DeployData
+	var truncated bool
+	for batch, offset := 0, 0; len(result) < skip+limit; batch, offset = batch+1, offset+deployListBatchSize {
+		if batch == maxDeployListBatches {
+			truncated = true
+			break
+		}
+		deploys, err := listDeploys(client, appName, offset, deployListBatchSize)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, deploy := range deploys {
+			if c.matches(&deploy) {
+				result = append(result, deploy)
+			}
+		}
+		if len(deploys) < deployListBatchSize {
+			break
+		}
+		// Deploys are listed from the newest to the oldest, so the next
+		// batches can't match --since.
+		if !c.since.IsZero() && deploys[len(deploys)-1].Timestamp.Before(c.since.Time) {
+			break
+		}
+	}
+	if len(result) <= skip {
+		return nil, truncated, nil
+	}
+	result = result[skip:]
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result, truncated, nil
+}
+
+func (c *appDeployList) filtering() bool {
+	return c.user != "" || c.origin != "" || c.status != "" || !c.since.IsZero() || !c.until.IsZero()
+}
+
+func (c *appDeployList) matches(deploy *tsuruapp.DeployData) bool {
+	origin := c.origin
+	if origin == "upload" {
+		origin = "app-deploy"
+	}
+	switch {
+	case c.user != "" && deploy.User != c.user:
+		return false
+	case origin != "" && deploy.Origin != origin:
+		return false
+	case c.status == "success" && deploy.Error != "":
+		return false
+	case c.status == "failure" && deploy.Error == "":
+		return false
+	case !c.since.IsZero() && deploy.Timestamp.Before(c.since.Time):
+		return false
+	case !c.until.IsZero() && !deploy.Timestamp.Before(c.until.Time):
+		return false
+	}
+	return true
+}
+
+// listDeploys returns deploys of the given app, or of all apps when appName
+// is empty, from the newest to the oldest.
+func listDeploys(client *cmd.Client, appName string, skip, limit int) ([]tsuruapp.DeployData, error) {
+	api, err := apiClient(client)
+	if err != nil {
+		return nil, err
+	}
+	return api.ListDeploys(appName, skip, limit)
+}
+
 type appDeploy struct {
 	cmd.GuessingCommand
+	fs           *gnuflag.FlagSet
+	exclude      stringList
+	dryRun       bool
+	retries      int
+	image        string
+	reproducible bool
+	follow       bool
+	skip         bool
+	noHooks      bool
 }
 
+func (*appDeploy) mutatesTarget() {}
+
 func (c *appDeploy) Info() *cmd.Info {
 	desc := `Deploys set of files and/or directories to tsuru server. Some examples of calls are:
 
 tsuru app-deploy .
 tsuru app-deploy myfile.jar Procfile
+tsuru app-deploy --image registry.example.com/myapp:v1
+
+Files inside the given directories are skipped when they match a pattern in the
+.tsuruignore file of the current directory, which uses the .gitignore syntax,
+or a pattern given with --exclude. Use --dry-run to list the files that would
+be deployed and the size of the archive, without deploying.
+
+Files are archived with their paths relative to the current directory, which
+is the root of the project: paths outside of it are rejected. Symlinks inside
+directories are archived as symlinks, and must point to files inside the
+project, unless --follow-symlinks is used to archive the files they point to.
+
+The SHA-256 digest of the uncompressed archive is printed before the upload.
+With --reproducible, the archive depends only on the names, contents and
+permissions of the files, so identical trees always have the same digest.
+
+With --skip-if-unchanged, the archive is always reproducible and its digest is
+recorded in ~/.tsuru after a successful deploy. When the digest of a later
+deploy matches the recorded one, and nobody deployed the app since then, the
+deploy is skipped and app-deploy exits with status 6.
+
+When the upload fails before the build starts, it's retried up to --retries
+times, waiting longer after each failure.
+
+With --image, no files are sent: the server deploys the given Docker image,
+which must be available in a registry reachable by the tsuru nodes. The flags
+that change the archive, --exclude, --reproducible, --skip-if-unchanged and
+--follow-symlinks, can't be used with it.
+
+Hooks can be declared in the .tsuru.yaml file of the project, found in the
+current directory or in its parents, as lists of shell commands under
+hooks/pre-deploy and hooks/post-deploy, which run in the directory of the file:
+
+hooks:
+  pre-deploy:
+    - make test
+  post-deploy:
+    - ./notify.sh
+
+Pre-deploy hooks run before the archive is built, and the deploy is aborted
+when any of them fails. With --skip-if-unchanged, the files are compared before
+the hooks run, and skipped deploys don't run them. Post-deploy hooks run after
+the deploy finishes, with the environment variables TSURU_APP_NAME,
+TSURU_DEPLOY_RESULT (success or failure), TSURU_DEPLOY_IMAGE and
+TSURU_DEPLOY_ERROR. TSURU_DEPLOY_IMAGE is empty when the image isn't known,
+like when another deploy of the app finished at the same time. Use --no-hooks
+to skip them.
+
+The exit status tells why a deploy failed: 3 for build failures, 4 for upload
+failures and 5 for failed healthchecks. Other errors exit with status 1. The
+server doesn't tell the kind of a failure, so errors that mention a
+healthcheck are taken as failed healthchecks.
 `
 	return &cmd.Info{
-		Name:    "app-deploy",
-		Usage:   "app-deploy [-a/--app <appname>] <file-or-dir-1> [file-or-dir-2] ... [file-or-dir-n]",
-		Desc:    desc,
-		MinArgs: 1,
+		Name:  "app-deploy",
+		Usage: "app-deploy [-a/--app <appname>] [--exclude <pattern>] [--dry-run] [--retries <n>] [--reproducible] [--follow-symlinks] [--skip-if-unchanged] [--no-hooks] <file-or-dir-1> [file-or-dir-2] ... [file-or-dir-n] | --image <image>",
+		Desc:  desc,
+	}
+}
+
+func (c *appDeploy) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = c.GuessingCommand.Flags()
+		c.fs.Var(&c.exclude, "exclude", "Pattern of files to leave out of the deploy, in .tsuruignore syntax (may be used multiple times)")
+		c.fs.BoolVar(&c.dryRun, "dry-run", false, "List the files that would be deployed, without deploying")
+		c.fs.IntVar(&c.retries, "retries", 3, "Number of times to retry the upload when it fails before the build starts")
+		c.fs.BoolVar(&c.follow, "follow-symlinks", false, "Archive the files and directories symlinks point to, instead of the symlinks")
+		c.fs.BoolVar(&c.skip, "skip-if-unchanged", false, "Don't deploy when the archive is the same as the one of the last deploy, exiting with status 6")
+		c.fs.BoolVar(&c.reproducible, "reproducible", false, "Build a reproducible archive, normalizing timestamps, owners and permissions")
+		c.fs.StringVar(&c.image, "image", "", "Docker image to deploy, instead of files (e.g. registry.example.com/myapp:v1)")
+		c.fs.BoolVar(&c.noHooks, "no-hooks", false, "Don't run the deploy hooks from .tsuru.yaml")
+	}
+	return c.fs
+}
+
+func (c *appDeploy) ignoreList() (ignoreList, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	ignore, err := readIgnoreFile(wd)
+	if err != nil {
+		return nil, err
+	}
+	for _, pattern := range c.exclude {
+		ignore.add(pattern)
 	}
+	return ignore, nil
 }
 
 func (c *appDeploy) Run(context *cmd.Context, client *cmd.Client) error {
-	var body bytes.Buffer
-	writer := multipart.NewWriter(&body)
-	file, err := writer.CreateFormFile("file", "archive.tar.gz")
+	err := c.deploy(context, client)
+	if err == errDeployUnchanged {
+		finisher().Exit(exitDeployUnchanged)
+		return nil
+	}
+	if e, ok := err.(*deployError); ok {
+		if code := e.exitCode(); code != 1 {
+			msg := e.Error()
+			if !strings.HasSuffix(msg, "\n") {
+				msg += "\n"
+			}
+			fmt.Fprint(context.Stderr, "Error: "+msg)
+			finisher().Exit(code)
+		}
+	}
+	return err
+}
+
+func (c *appDeploy) deploy(context *cmd.Context, client *cmd.Client) error {
+	if c.image != "" && (len(context.Args) > 0 || c.dryRun) {
+		return errors.New("You can't deploy files or use --dry-run when deploying an image.")
+	}
+	if c.image != "" {
+		var archiveFlags []string
+		if len(c.exclude) > 0 {
+			archiveFlags = append(archiveFlags, "--exclude")
+		}
+		if c.reproducible {
+			archiveFlags = append(archiveFlags, "--reproducible")
+		}
+		if c.skip {
+			archiveFlags = append(archiveFlags, "--skip-if-unchanged")
+		}
+		if c.follow {
+			archiveFlags = append(archiveFlags, "--follow-symlinks")
+		}
+		if len(archiveFlags) > 0 {
+			return fmt.Errorf("You can't use %s when deploying an image, as no files are archived.", strings.Join(archiveFlags, ", "))
+		}
+	}
+	if c.image == "" && len(context.Args) == 0 {
+		return errors.New("You should provide at least one file or directory to deploy, or an image with --image.")
+	}
+	if c.dryRun || c.noHooks {
+		return c.deployApp(context, client, nil)
+	}
+	wd, err := os.Getwd()
 	if err != nil {
 		return err
 	}
-	err = targz(context, file, context.Args...)
+	config, path, err := findProjectConfig(wd)
 	if err != nil {
 		return err
 	}
-	writer.Close()
+	hooks := config.Hooks
+	root := filepath.Dir(path)
+	if len(hooks.PreDeploy) == 0 && len(hooks.PostDeploy) == 0 {
+		return c.deployApp(context, client, nil)
+	}
 	appName, err := c.Guess()
 	if err != nil {
 		return err
 	}
-	url, err := cmd.GetURL("/apps/" + appName + "/deploy")
+	envs := append(os.Environ(), "TSURU_APP_NAME="+appName)
+	var previous *tsuruapp.DeployData
+	var hookErr, previousErr error
+	err = c.deployApp(context, client, func() error {
+		hookErr = runHooks(context, "pre-deploy", root, hooks.PreDeploy, envs)
+		if hookErr == nil && c.image == "" && len(hooks.PostDeploy) > 0 {
+			previous, previousErr = latestDeploy(client, appName)
+		}
+		return hookErr
+	})
+	if err == errDeployUnchanged || hookErr != nil || len(hooks.PostDeploy) == 0 {
+		return err
+	}
+	result, image, deployErr := "success", c.image, ""
+	if err != nil {
+		result, deployErr = "failure", err.Error()
+	} else if image == "" && previousErr == nil {
+		image = deployedImage(client, appName, previous)
+	}
+	envs = append(envs,
+		"TSURU_DEPLOY_RESULT="+result,
+		"TSURU_DEPLOY_IMAGE="+image,
+		"TSURU_DEPLOY_ERROR="+deployErr,
+	)
+	if hErr := runHooks(context, "post-deploy", root, hooks.PostDeploy, envs); hErr != nil {
+		fmt.Fprintf(context.Stderr, "Warning: %s\n", hErr)
+	}
+	return err
+}
+
+// deployedImage returns the image of a deploy that just succeeded, given the
+// newest deploy of the app before it started. The server doesn't send the
+// image in the response of the deploy, so it's taken from the list of deploys,
+// and isn't known when another deploy of the app happened in the meantime.
+func deployedImage(client *cmd.Client, appName string, previous *tsuruapp.DeployData) string {
+	deploys, err := listDeploys(client, appName, 0, 2)
+	if err != nil || len(deploys) == 0 {
+		return ""
+	}
+	if previous == nil && len(deploys) == 1 {
+		return deploys[0].Image
+	}
+	if previous != nil && len(deploys) == 2 && deployIdentity(&deploys[1]) == deployIdentity(previous) {
+		return deploys[0].Image
+	}
+	return ""
+}
+
+// deployApp deploys the files given in the command line, or the image given
+// with --image. preDeploy, when not nil, is called before the archive is
+// generated, once it's known the deploy won't be skipped by
+// --skip-if-unchanged, as it may create or change the files to deploy.
+func (c *appDeploy) deployApp(context *cmd.Context, client *cmd.Client, preDeploy func() error) error {
+	if c.image != "" {
+		if preDeploy != nil {
+			if err := preDeploy(); err != nil {
+				return err
+			}
+		}
+		return c.deployImage(context, client)
+	}
+	ignore, err := c.ignoreList()
 	if err != nil {
 		return err
 	}
-	request, err := http.NewRequest("POST", url, &body)
+	opts := archiveOptions{
+		ignore:         ignore,
+		reproducible:   c.reproducible || c.skip,
+		followSymlinks: c.follow,
+	}
+	if c.dryRun {
+		return deployDryRun(context, &opts)
+	}
+	appName, err := c.Guess()
 	if err != nil {
 		return err
 	}
-	request.Header.Set("Content-Type", "multipart/form-data; boundary="+writer.Boundary())
-	var buf bytes.Buffer
-	respBody := firstWriter{Writer: io.MultiWriter(context.Stdout, &buf)}
-	go func() {
-		fmt.Fprint(context.Stdout, "Uploading files..")
-		for buf.Len() == 0 {
-			fmt.Fprint(context.Stdout, ".")
-			time.Sleep(2e9)
+	api, err := apiClient(client)
+	if err != nil {
+		return err
+	}
+	var digest, target string
+	var total int64
+	var cache deployCache
+	if c.skip {
+		// The files are compared with the ones of the last deploy before
+		// the pre-deploy hooks run, so skipped deploys don't run them.
+		digest, total, err = archiveDigest(&opts, context.Args...)
+		if err != nil {
+			return &deployError{phase: deployPhaseArchiving, err: err}
+		}
+		fmt.Fprintf(context.Stdout, "Archive digest: %s\n", digest)
+		target, err = cmd.ReadTarget()
+		if err != nil {
+			return err
+		}
+		cache, err = loadDeployCache()
+		if err != nil {
+			return err
 		}
-	}()
-	resp, err := client.Do(request)
+		unchanged, err := archiveUnchanged(client, cache, target, appName, digest)
+		if err != nil {
+			return err
+		}
+		if unchanged {
+			fmt.Fprintf(context.Stdout, "The archive didn't change since the last deploy of app %q, skipping.\n", appName)
+			return errDeployUnchanged
+		}
+	}
+	if preDeploy != nil || !c.skip {
+		if preDeploy != nil {
+			if err = preDeploy(); err != nil {
+				return err
+			}
+		}
+		var uploaded string
+		uploaded, total, err = archiveDigest(&opts, context.Args...)
+		if err != nil {
+			return &deployError{phase: deployPhaseArchiving, err: err}
+		}
+		if !c.skip {
+			fmt.Fprintf(context.Stdout, "Archive digest: %s\n", uploaded)
+		} else if uploaded != digest {
+			fmt.Fprintf(context.Stdout, "Archive digest after the pre-deploy hooks: %s\n", uploaded)
+		}
+	}
+	stream, err := c.upload(context, api, appName, &opts, total)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	_, err = io.Copy(&respBody, resp.Body)
+	defer stream.Close()
+	err = readBuildOutput(context, stream)
+	if err == nil && c.skip {
+		err = recordDeploy(client, cache, target, appName, digest)
+		if err != nil {
+			fmt.Fprintf(context.Stderr, "Warning: failed to record the digest of the deploy: %s\n", err)
+		}
+		return nil
+	}
+	return err
+}
+
+// archiveUnchanged tells whether the archive with the given digest is the one
+// of the last deploy of the app, as recorded in the cache and the server.
+func archiveUnchanged(client *cmd.Client, cache deployCache, target, appName, digest string) (bool, error) {
+	entry, ok := cache.get(target, appName)
+	if !ok || entry.Digest != digest {
+		return false, nil
+	}
+	deploy, err := latestDeploy(client, appName)
+	if err != nil || deploy == nil {
+		return false, err
+	}
+	return deploy.Error == "" && deployIdentity(deploy) == entry.Deploy, nil
+}
+
+// recordDeploy saves the digest of a successful deploy in the cache, along
+// with the deploy it resulted in.
+func recordDeploy(client *cmd.Client, cache deployCache, target, appName, digest string) error {
+	deploy, err := latestDeploy(client, appName)
 	if err != nil {
 		return err
 	}
+	if deploy == nil {
+		return errors.New("the deploy isn't listed by the server")
+	}
+	cache.set(target, appName, deployCacheEntry{Digest: digest, Deploy: deployIdentity(deploy)})
+	return cache.save()
+}
+
+// readBuildOutput writes the output of the build of a deploy, returning an
+// error when the deploy fails.
+func readBuildOutput(context *cmd.Context, stream *tsuruClient.Stream) error {
+	out := firstWriter{Writer: context.Stdout}
+	if stream.ContentType == tsuruClient.JSONStreamContentType {
+		return buildFailure(streamOutput(&out, stream, deployFormatter{}))
+	}
+	// Servers that don't stream JSON messages send the build output as plain
+	// text, ending it with an "OK" line when the deploy succeeds.
+	var buf bytes.Buffer
+	_, err := io.Copy(io.MultiWriter(&out, &buf), stream)
+	if err != nil {
+		return &deployError{phase: deployPhaseBuild, err: err}
+	}
 	if strings.HasSuffix(buf.String(), "\nOK\n") {
 		return nil
 	}
-	return cmd.ErrAbortCommand
+	return &deployError{phase: deployPhaseBuild, err: errors.New("the server didn't report a successful deploy")}
 }
 
-func targz(ctx *cmd.Context, destination io.Writer, filepaths ...string) error {
-	var buf bytes.Buffer
-	tarWriter := tar.NewWriter(&buf)
+// deployImage asks the server to deploy a prebuilt Docker image, streaming
+// the output of the deploy.
+func (c *appDeploy) deployImage(context *cmd.Context, client *cmd.Client) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	api, err := apiClient(client)
+	if err != nil {
+		return err
+	}
+	stream, err := api.DeployImage(appName, c.image)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	return buildFailure(streamOutput(context.Stdout, stream, deployFormatter{}))
+}
+
+// streamOutput writes the messages streamed by the server during long
+// operations, like deploys, rollbacks and changes to environment variables,
+// to w, returning the first error reported by the server. A nil formatter
+// uses the default one from tsuru/io.
+func streamOutput(w io.Writer, body io.Reader, formatter tsuruIo.Formatter) error {
+	stream := tsuruIo.NewStreamWriter(w, formatter)
+	var err error
+	for n := int64(1); n > 0 && err == nil; n, err = io.Copy(stream, body) {
+	}
+	if err != nil {
+		return err
+	}
+	unparsed := stream.Remaining()
+	if len(unparsed) > 0 {
+		return fmt.Errorf("unparsed message error: %s", string(unparsed))
+	}
+	return nil
+}
+
+// buildFailure makes errors that happen while reading the output of a deploy
+// build failures, unless they already tell the failing phase.
+func buildFailure(err error) error {
+	if _, ok := err.(*deployError); ok || err == nil {
+		return err
+	}
+	return &deployError{phase: deployPhaseBuild, err: err}
+}
+
+// deployFormatter formats the JSON messages streamed by the server during a
+// deploy, turning the errors reported in the stream into deployErrors.
+type deployFormatter struct{}
+
+func (deployFormatter) Format(out io.Writer, data []byte) error {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	var msg tsuruIo.SimpleJsonMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return tsuruIo.ErrInvalidStreamChunk
+	}
+	if msg.Error != "" {
+		return &deployError{phase: failurePhase(msg.Error), err: errors.New(msg.Error)}
+	}
+	_, err := out.Write([]byte(msg.Message))
+	return err
+}
+
+// Delays between upload attempts, doubled after each failure.
+var (
+	uploadRetryDelay    = time.Second
+	maxUploadRetryDelay = 30 * time.Second
+)
+
+// upload sends the archive to the server, retrying with exponential backoff
+// while the failures happen before the server starts the build.
+func (c *appDeploy) upload(context *cmd.Context, api *tsuruClient.Client, appName string, opts *archiveOptions, total int64) (*tsuruClient.Stream, error) {
+	delay := uploadRetryDelay
+	for attempt := 1; ; attempt++ {
+		stream, retry, err := uploadArchive(context, api, appName, opts, total)
+		if err == nil || !retry || attempt > c.retries {
+			return stream, err
+		}
+		fmt.Fprintf(context.Stderr, "%s\nRetrying in %s (%d of %d)...\n", err, delay, attempt, c.retries)
+		time.Sleep(delay)
+		delay *= 2
+		if delay > maxUploadRetryDelay {
+			delay = maxUploadRetryDelay
+		}
+	}
+}
+
+// uploadArchive makes a single attempt to stream the archive to the server,
+// returning the stream from which the build output is read. When it fails,
+// it also reports whether it's safe to try again.
+func uploadArchive(context *cmd.Context, api *tsuruClient.Client, appName string, opts *archiveOptions, total int64) (*tsuruClient.Stream, bool, error) {
+	progress := newUploadProgress(context.Stdout, total)
+	archiveOpts := *opts
+	archiveOpts.progress = progress
+	archiveReader, archiveWriter := io.Pipe()
+	archiveErr := make(chan error, 1)
+	// The archive is only generated once the client starts sending it, so
+	// nothing is archived when the request fails before the upload.
+	archive := &firstReader{Reader: archiveReader, first: func() {
+		go func() {
+			err := targz(context, progress.sent(archiveWriter), &archiveOpts, context.Args...)
+			archiveWriter.CloseWithError(err)
+			archiveErr <- err
+		}()
+	}}
+	progress.start()
+	stream, err := api.Deploy(appName, archive)
+	archiveReader.Close()
+	progress.stop()
+	archive.once.Do(func() {
+		archiveErr <- io.ErrClosedPipe
+	})
+	aErr := <-archiveErr
+	if aErr != nil && aErr != io.ErrClosedPipe {
+		if stream != nil {
+			stream.Close()
+		}
+		fmt.Fprintln(context.Stdout)
+		return nil, false, &deployError{phase: deployPhaseArchiving, err: aErr}
+	}
+	if err != nil {
+		fmt.Fprintln(context.Stdout)
+		// Once the whole archive is sent the server may be building it, even
+		// when a gateway times out, so only failures that interrupted the
+		// upload are worth retrying, unless the server refused the request.
+		retry := aErr == io.ErrClosedPipe
+		if e, ok := err.(*tsuruErrors.HTTP); ok {
+			retry = retry && (e.Code == http.StatusBadGateway || e.Code == http.StatusServiceUnavailable ||
+				e.Code == http.StatusGatewayTimeout)
+		}
+		return nil, retry, &deployError{phase: deployPhaseUpload, err: err}
+	}
+	return stream, false, nil
+}
+
+// Phases of a deploy, used to tell users where a deploy failed.
+const (
+	deployPhaseArchiving   = "archiving"
+	deployPhaseUpload      = "upload"
+	deployPhaseBuild       = "build"
+	deployPhaseHealthcheck = "healthcheck"
+)
+
+// failurePhase returns the phase of a deploy that failed on the server with
+// the given error. The messages streamed by the server have no field telling
+// the kind of the failure, so it's guessed from the error: the errors of
+// failed healthchecks always mention them, and other errors are build
+// failures. A build error that happens to mention a healthcheck is taken as a
+// failed healthcheck.
+func failurePhase(err string) string {
+	if strings.Contains(strings.ToLower(err), "healthcheck") {
+		return deployPhaseHealthcheck
+	}
+	return deployPhaseBuild
+}
+
+// Exit statuses of app-deploy, telling pipelines why a deploy failed or was
+// skipped. Other failures, including the ones in the archiving phase, exit
+// with status 1.
+const (
+	exitDeployBuildFailure       = 3
+	exitDeployUploadFailure      = 4
+	exitDeployHealthcheckFailure = 5
+	exitDeployUnchanged          = 6
+)
+
+// errDeployUnchanged is returned when --skip-if-unchanged skips a deploy.
+var errDeployUnchanged = errors.New("the archive didn't change since the last deploy")
+
+// deployError is a failure in one of the phases of a deploy.
+type deployError struct {
+	phase string
+	err   error
+}
+
+func (e *deployError) Error() string {
+	return fmt.Sprintf("%s failed: %s", e.phase, e.err)
+}
+
+func (e *deployError) exitCode() int {
+	switch e.phase {
+	case deployPhaseBuild:
+		return exitDeployBuildFailure
+	case deployPhaseUpload:
+		return exitDeployUploadFailure
+	case deployPhaseHealthcheck:
+		return exitDeployHealthcheckFailure
+	}
+	return 1
+}
+
+func deployDryRun(context *cmd.Context, opts *archiveOptions) error {
+	var files int
+	var size byteCounter
+	digest := sha256.New()
+	opts.visit = func(name string, fi os.FileInfo) {
+		if !fi.IsDir() {
+			files++
+			fmt.Fprintln(context.Stdout, name)
+		}
+	}
+	opts.digest = digest
+	err := targz(context, &size, opts, context.Args...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "\n%d files, archive size: %s\n", files, formatSize(int64(size)))
+	fmt.Fprintf(context.Stdout, "Archive digest: %s\n", formatDigest(digest))
+	return nil
+}
+
+// archiveOptions controls which entries targz adds to the archive.
+type archiveOptions struct {
+	ignore ignoreList
+	// root is the directory entry names are relative to, defaulting to the
+	// working directory. Paths outside of it can't be archived.
+	root string
+	// followSymlinks makes symlinks inside directories be archived as the
+	// files or directories they point to. Otherwise they're archived as
+	// symlinks, which must point to paths inside the root.
+	followSymlinks bool
+	// reproducible makes archives depend only on the names, contents and
+	// permissions of the files, normalizing timestamps and owners.
+	reproducible bool
+	// visit, when not nil, is called with the name and the file info of
+	// every entry added to the archive.
+	visit func(name string, fi os.FileInfo)
+	// progress, when not nil, receives a copy of the contents of every file
+	// added to the archive.
+	progress io.Writer
+	// digest, when not nil, receives a copy of the uncompressed archive.
+	digest io.Writer
+}
+
+func (o *archiveOptions) skip(name string, fi os.FileInfo) bool {
+	return o.ignore.ignored(name, fi.IsDir())
+}
+
+// header returns the tar header of the given entry, normalized when building
+// reproducible archives.
+func (o *archiveOptions) header(name string, fi os.FileInfo, link string) (*tar.Header, error) {
+	header, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return nil, err
+	}
+	header.Name = name
+	if o.reproducible {
+		header.ModTime = time.Unix(0, 0)
+		header.AccessTime = time.Time{}
+		header.ChangeTime = time.Time{}
+		header.Uid, header.Gid = 0, 0
+		header.Uname, header.Gname = "", ""
+		if fi.IsDir() || fi.Mode()&0111 != 0 {
+			header.Mode = 0755
+		} else {
+			header.Mode = 0644
+		}
+	}
+	return header, nil
+}
+
+func targz(ctx *cmd.Context, destination io.Writer, opts *archiveOptions, filepaths ...string) error {
+	if opts == nil {
+		opts = &archiveOptions{}
+	}
 	for _, path := range filepaths {
 		if path == ".." {
 			fmt.Fprintf(ctx.Stderr, "Warning: skipping %q", path)
+		}
+	}
+	gzipWriter := gzip.NewWriter(destination)
+	var w io.Writer = gzipWriter
+	if opts.digest != nil {
+		w = io.MultiWriter(gzipWriter, opts.digest)
+	}
+	err := writeTar(w, opts, filepaths...)
+	if err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+// writeTar writes an uncompressed archive of the given paths to w. Entries
+// are named after their paths relative to the root, and the entries of
+// directories are added in lexical order. The parent directory, "..", is
+// never added.
+func writeTar(w io.Writer, opts *archiveOptions, filepaths ...string) error {
+	root := opts.root
+	if root == "" {
+		var err error
+		root, err = os.Getwd()
+		if err != nil {
+			return err
+		}
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	tarWriter := tar.NewWriter(w)
+	walker := archiveWalker{opts: opts, dirs: make(map[string]bool)}
+	walker.walkFn = func(path, name string, fi os.FileInfo) error {
+		var err error
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			err = addSymlink(tarWriter, path, name, fi, opts)
+		case fi.IsDir():
+			err = addDir(tarWriter, name, fi, opts)
+		default:
+			err = addFile(tarWriter, path, name, opts)
+		}
+		if err == nil && opts.visit != nil {
+			opts.visit(name, fi)
+		}
+		return err
+	}
+	for _, path := range filepaths {
+		if path == ".." {
 			continue
 		}
 		fi, err := os.Stat(path)
 		if err != nil {
 			return err
 		}
-		if fi.IsDir() {
-			err = addDir(tarWriter, path)
-		} else {
-			err = addFile(tarWriter, path)
+		name, err := entryName(root, path)
+		if err != nil {
+			return err
 		}
+		err = walker.walk(path, name, fi)
 		if err != nil {
 			return err
 		}
 	}
-	err := tarWriter.Close()
-	if err != nil {
-		return err
-	}
-	gzipWriter := gzip.NewWriter(destination)
-	defer gzipWriter.Close()
-	_, err = io.Copy(gzipWriter, &buf)
-	return err
+	return tarWriter.Close()
 }
 
-func addDir(writer *tar.Writer, path string) error {
-	dir, err := os.Open(path)
+// entryName returns the name of path in an archive of the given root
+// directory, failing when path is outside of it.
+func entryName(root, path string) (string, error) {
+	abs, err := filepath.Abs(path)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer dir.Close()
-	fi, err := dir.Stat()
+	rel, err := filepath.Rel(root, abs)
 	if err != nil {
-		return err
+		return "", err
 	}
-	header, err := tar.FileInfoHeader(fi, "")
-	if err != nil {
-		return err
+	rel = filepath.ToSlash(rel)
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("%s is outside of the project root, %s", path, root)
 	}
-	header.Name = path
-	err = writer.WriteHeader(header)
+	return rel, nil
+}
+
+// archiveDigest returns the digest of the uncompressed archive of the given
+// paths, along with the sum of the sizes of the files in it. The files are
+// read, but nothing is written.
+func archiveDigest(opts *archiveOptions, filepaths ...string) (string, int64, error) {
+	var size int64
+	digestOpts := *opts
+	digestOpts.progress = nil
+	digestOpts.visit = func(name string, fi os.FileInfo) {
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+	}
+	digest := sha256.New()
+	err := writeTar(digest, &digestOpts, filepaths...)
 	if err != nil {
+		return "", 0, err
+	}
+	return formatDigest(digest), size, nil
+}
+
+func formatDigest(h hash.Hash) string {
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// archiveWalker walks the files added to deploy archives.
+type archiveWalker struct {
+	opts   *archiveOptions
+	walkFn func(path, name string, fi os.FileInfo) error
+	// dirs holds the real paths of the directories being walked, to detect
+	// cycles when following symlinks.
+	dirs map[string]bool
+}
+
+// walk calls walkFn for path and, when it's a directory, for every entry
+// below it that isn't skipped by the options.
+func (w *archiveWalker) walk(path, name string, fi os.FileInfo) error {
+	err := w.walkFn(path, name, fi)
+	if err != nil || !fi.IsDir() {
 		return err
 	}
-	fis, err := dir.Readdir(0)
+	if w.opts.followSymlinks {
+		realPath, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return err
+		}
+		if w.dirs[realPath] {
+			return fmt.Errorf("symlink cycle: %s is %s, which is one of its parent directories", path, realPath)
+		}
+		w.dirs[realPath] = true
+		defer delete(w.dirs, realPath)
+	}
+	fis, err := ioutil.ReadDir(path)
 	if err != nil {
 		return err
 	}
 	for _, fi := range fis {
-		if fi.IsDir() {
-			err = addDir(writer, filepath.Join(path, fi.Name()))
-		} else {
-			err = addFile(writer, filepath.Join(path, fi.Name()))
+		childPath := filepath.Join(path, fi.Name())
+		childName := pathpkg.Join(name, fi.Name())
+		if fi.Mode()&os.ModeSymlink != 0 && w.opts.followSymlinks {
+			fi, err = os.Stat(childPath)
+			if err != nil {
+				return err
+			}
 		}
+		if w.opts.skip(childName, fi) {
+			continue
+		}
+		err = w.walk(childPath, childName, fi)
 		if err != nil {
 			return err
 		}
@@ -234,7 +1070,31 @@ func addDir(writer *tar.Writer, path string) error {
 	return nil
 }
 
-func addFile(writer *tar.Writer, path string) error {
+func addDir(writer *tar.Writer, name string, fi os.FileInfo, opts *archiveOptions) error {
+	header, err := opts.header(name, fi, "")
+	if err != nil {
+		return err
+	}
+	return writer.WriteHeader(header)
+}
+
+func addSymlink(writer *tar.Writer, path, name string, fi os.FileInfo, opts *archiveOptions) error {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return err
+	}
+	resolved := pathpkg.Join(pathpkg.Dir(name), filepath.ToSlash(target))
+	if filepath.IsAbs(target) || resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return fmt.Errorf("symlink %s points to %s, outside of the project root; use --follow-symlinks to archive the files it points to", path, target)
+	}
+	header, err := opts.header(name, fi, target)
+	if err != nil {
+		return err
+	}
+	return writer.WriteHeader(header)
+}
+
+func addFile(writer *tar.Writer, path, name string, opts *archiveOptions) error {
 	f, err := os.Open(path)
 	if err != nil {
 		return err
@@ -244,16 +1104,19 @@ func addFile(writer *tar.Writer, path string) error {
 	if err != nil {
 		return err
 	}
-	header, err := tar.FileInfoHeader(fi, "")
+	header, err := opts.header(name, fi, "")
 	if err != nil {
 		return err
 	}
-	header.Name = path
 	err = writer.WriteHeader(header)
 	if err != nil {
 		return err
 	}
-	n, err := io.Copy(writer, f)
+	var dst io.Writer = writer
+	if opts.progress != nil {
+		dst = io.MultiWriter(writer, opts.progress)
+	}
+	n, err := io.Copy(dst, f)
 	if err != nil {
 		return err
 	}
@@ -263,6 +1126,29 @@ func addFile(writer *tar.Writer, path string) error {
 	return nil
 }
 
+// byteCounter is a writer that discards its input, keeping only the number
+// of bytes written.
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// formatSize renders a number of bytes in a human readable form.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 type firstWriter struct {
 	io.Writer
 	once sync.Once
@@ -275,29 +1161,49 @@ func (w *firstWriter) Write(p []byte) (int, error) {
 	return w.Writer.Write(p)
 }
 
+// firstReader calls first before the first read from the underlying reader.
+type firstReader struct {
+	io.Reader
+	first func()
+	once  sync.Once
+}
+
+func (r *firstReader) Read(p []byte) (int, error) {
+	r.once.Do(r.first)
+	return r.Reader.Read(p)
+}
+
 type appDeployRollback struct {
 	cmd.GuessingCommand
-	cmd.ConfirmationCommand
-	fs *gnuflag.FlagSet
+	protectedConfirmation
+	fs       *gnuflag.FlagSet
+	previous bool
 }
 
+func (*appDeployRollback) mutatesTarget() {}
+
 func (c *appDeployRollback) Flags() *gnuflag.FlagSet {
 	if c.fs == nil {
 		c.fs = cmd.MergeFlagSet(
 			c.GuessingCommand.Flags(),
-			c.ConfirmationCommand.Flags(),
+			c.protectedConfirmation.Flags(),
 		)
+		c.fs.BoolVar(&c.previous, "previous", false, "Rollback to the last successful image before the current one")
 	}
 	return c.fs
 }
 
 func (c *appDeployRollback) Info() *cmd.Info {
-	desc := "Deploys an existing image for an app. You can list available images with `tsuru app-deploy-list`."
+	desc := `Deploys an existing image for an app. You can list available images with ` + "`tsuru app-deploy-list`" + `.
+
+When no image is given, the deploys that can be rolled back to are listed, and
+you're asked to choose one of them. Use --previous to rollback to the last
+successful image before the current one, without being asked for it.`
 	return &cmd.Info{
 		Name:    "app-deploy-rollback",
-		Usage:   "app-deploy-rollback [-a/--app appname] [-y/--assume-yes] <image-name>",
+		Usage:   "app-deploy-rollback [-a/--app appname] [-y/--assume-yes] [--i-know-this-is-prod] [--previous] [image-name]",
 		Desc:    desc,
-		MinArgs: 1,
+		MaxArgs: 1,
 	}
 }
 
@@ -306,34 +1212,309 @@ func (c *appDeployRollback) Run(context *cmd.Context, client *cmd.Client) error
 	if err != nil {
 		return err
 	}
-	imgName := context.Args[0]
-	if !c.Confirm(context, fmt.Sprintf("Are you sure you want to rollback app %q to image %q?", appName, imgName)) {
-		return nil
+	var imgName string
+	switch {
+	case len(context.Args) > 0 && c.previous:
+		return errors.New("You can't use --previous and give an image at the same time.")
+	case len(context.Args) > 0:
+		imgName = context.Args[0]
+	case c.previous:
+		imgName, err = previousImage(client, appName)
+		if err != nil {
+			return err
+		}
+	default:
+		// Choosing the image is the confirmation, unless the app is
+		// protected.
+		imgName, err = pickRollbackImage(context, client, appName)
+		if err != nil || imgName == "" {
+			return err
+		}
+		if ok, err := c.confirmProtected(context, appName); !ok || err != nil {
+			return err
+		}
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/deploy/rollback", appName))
+	if len(context.Args) > 0 || c.previous {
+		question := fmt.Sprintf("Are you sure you want to rollback app %q to image %q?", appName, imgName)
+		if ok, err := c.confirm(context, question, appName); !ok || err != nil {
+			return err
+		}
+	}
+	api, err := apiClient(client)
 	if err != nil {
 		return err
 	}
-	body := strings.NewReader("image=" + imgName)
-	request, err := http.NewRequest("POST", url, body)
+	stream, err := api.Rollback(appName, imgName)
 	if err != nil {
 		return err
 	}
-	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	response, err := client.Do(request)
+	defer stream.Close()
+	return streamOutput(context.Stdout, stream, nil)
+}
+
+// previousImage returns the image of the last successful deploy of the app
+// before the current one that can still be rolled back to.
+func previousImage(client *cmd.Client, appName string) (string, error) {
+	deploys, err := listDeploys(client, appName, 0, deployListBatchSize)
+	if err != nil {
+		return "", err
+	}
+	var current string
+	for _, deploy := range deploys {
+		if deploy.Error != "" || deploy.Image == "" {
+			continue
+		}
+		if current == "" {
+			current = deploy.Image
+		} else if deploy.Image != current && deploy.CanRollback {
+			return deploy.Image, nil
+		}
+	}
+	return "", fmt.Errorf("no successful deploy before the current one found in the last %d deploys of app %q", deployListBatchSize, appName)
+}
+
+// pickRollbackImage lists the deploys of the app that can be rolled back to
+// and asks the user to choose one of them. It returns an empty image when the
+// user doesn't choose any.
+func pickRollbackImage(context *cmd.Context, client *cmd.Client, appName string) (string, error) {
+	deploys, err := listDeploys(client, appName, 0, deployListBatchSize)
+	if err != nil {
+		return "", err
+	}
+	var candidates []tsuruapp.DeployData
+	for _, deploy := range deploys {
+		if deploy.CanRollback {
+			candidates = append(candidates, deploy)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("app %q has no deploys to rollback to", appName)
+	}
+	table := cmd.NewTable()
+	table.Headers = cmd.Row([]string{"#", "Image", "Origin", "User", "Date"})
+	for i, deploy := range candidates {
+		origin := deploy.Origin
+		if origin == "" && deploy.Commit != "" {
+			origin = "git"
+		}
+		table.AddRow(cmd.Row([]string{
+			strconv.Itoa(i + 1),
+			deploy.Image,
+			origin,
+			deploy.User,
+			deploy.Timestamp.Local().Format(time.Stamp),
+		}))
+	}
+	fmt.Fprintf(context.Stdout, "Deploys of app %q available for rollback:\n", appName)
+	context.Stdout.Write(table.Bytes())
+	fmt.Fprintf(context.Stdout, "Choose the image to rollback to (1-%d): ", len(candidates))
+	var answer string
+	if n, err := fmt.Fscanf(context.Stdin, "%s", &answer); n != 1 || err != nil {
+		fmt.Fprintln(context.Stdout, "Abort.")
+		return "", nil
+	}
+	choice, err := strconv.Atoi(answer)
+	if err != nil || choice < 1 || choice > len(candidates) {
+		return "", fmt.Errorf("invalid choice %q, it must be a number between 1 and %d", answer, len(candidates))
+	}
+	return candidates[choice-1].Image, nil
+}
+
+type appDeployDiff struct {
+	cmd.GuessingCommand
+}
+
+func (c *appDeployDiff) Info() *cmd.Info {
+	desc := `Compares two deploys, side by side, highlighting the information that changed
+from the first to the second deploy. Deploys are given by their IDs, or by
+their images, as listed by app-deploy-list, like tsuru/app-myapp:v3 or just v3.
+
+When both deploys come from git, the range of commits between them is printed,
+ready to be given to git log.
+
+Differences in environment variables aren't shown: the server doesn't record
+the environment of deploys. Use env-get to see the current variables.`
+	return &cmd.Info{
+		Name:    "app-deploy-diff",
+		Usage:   "app-deploy-diff [-a/--app <appname>] <deploy> <deploy>",
+		Desc:    desc,
+		MinArgs: 2,
+		MaxArgs: 2,
+	}
+}
+
+func (c *appDeployDiff) Run(context *cmd.Context, client *cmd.Client) error {
+	a, err := c.deploy(client, context.Args[0])
 	if err != nil {
 		return err
 	}
-	defer response.Body.Close()
-	w := tsuruIo.NewStreamWriter(context.Stdout, nil)
-	for n := int64(1); n > 0 && err == nil; n, err = io.Copy(w, response.Body) {
+	b, err := c.deploy(client, context.Args[1])
+	if err != nil {
+		return err
+	}
+	rows := [][3]string{
+		{"App", a.App, b.App},
+		{"Image", a.Image, b.Image},
+		{"Origin", a.Origin, b.Origin},
+		{"Commit", a.Commit, b.Commit},
+		{"User", a.User, b.User},
+		{"Date", a.Timestamp.Local().Format(time.Stamp), b.Timestamp.Local().Format(time.Stamp)},
+		{"Duration", deployDuration(a.Duration), deployDuration(b.Duration)},
+		{"Error", a.Error, b.Error},
+	}
+	table := cmd.NewTable()
+	table.Headers = cmd.Row([]string{"", context.Args[0], context.Args[1]})
+	for _, row := range rows {
+		if row[1] != row[2] {
+			row[2] = cmd.Colorfy(row[2], "yellow", "", "")
+		}
+		table.AddRow(cmd.Row(row[:]))
+	}
+	context.Stdout.Write(table.Bytes())
+	if a.Origin == "git" && b.Origin == "git" && a.Commit != "" && b.Commit != "" && a.Commit != b.Commit {
+		older, newer := a, b
+		if newer.Timestamp.Before(older.Timestamp) {
+			older, newer = newer, older
+		}
+		commitRange := older.Commit + ".." + newer.Commit
+		fmt.Fprintf(context.Stdout, "\nCommit range: %s\nSee the commits with: git log %s\n", commitRange, commitRange)
+	}
+	return nil
+}
+
+func (c *appDeployDiff) deploy(client *cmd.Client, ref string) (*tsuruapp.DeployData, error) {
+	return findDeploy(client, &c.GuessingCommand, ref)
+}
+
+type appDeployInfo struct {
+	cmd.GuessingCommand
+}
+
+func (c *appDeployInfo) Info() *cmd.Info {
+	desc := `Shows the information and the full build log of a deploy, given by its ID or
+by its image, as listed by app-deploy-list, like tsuru/app-myapp:v3 or just v3.
+
+The steps that failed are highlighted in the log.`
+	return &cmd.Info{
+		Name:    "app-deploy-info",
+		Usage:   "app-deploy-info [-a/--app <appname>] <deploy>",
+		Desc:    desc,
+		MinArgs: 1,
+		MaxArgs: 1,
 	}
+}
+
+func (c *appDeployInfo) Run(context *cmd.Context, client *cmd.Client) error {
+	deploy, err := findDeploy(client, &c.GuessingCommand, context.Args[0])
 	if err != nil {
 		return err
 	}
-	unparsed := w.Remaining()
-	if len(unparsed) > 0 {
-		return fmt.Errorf("unparsed message error: %s", string(unparsed))
+	origin := deploy.Origin
+	if origin == "" && deploy.Commit != "" {
+		origin = "git"
+	}
+	fmt.Fprintf(context.Stdout, "ID: %s\n", deploy.ID.Hex())
+	fmt.Fprintf(context.Stdout, "App: %s\n", deploy.App)
+	fmt.Fprintf(context.Stdout, "Image: %s\n", deploy.Image)
+	fmt.Fprintf(context.Stdout, "Origin: %s\n", origin)
+	if deploy.Commit != "" {
+		fmt.Fprintf(context.Stdout, "Commit: %s\n", deploy.Commit)
+	}
+	fmt.Fprintf(context.Stdout, "User: %s\n", deploy.User)
+	fmt.Fprintf(context.Stdout, "Date: %s\n", deploy.Timestamp.Local().Format(time.Stamp))
+	fmt.Fprintf(context.Stdout, "Duration: %s\n", deployDuration(deploy.Duration))
+	if deploy.Error != "" {
+		fmt.Fprintf(context.Stdout, "Error: %s\n", cmd.Colorfy(deploy.Error, "red", "", ""))
+	}
+	if deploy.Log == "" {
+		fmt.Fprintln(context.Stdout, "\nThe server didn't record the log of this deploy.")
+		return nil
+	}
+	fmt.Fprint(context.Stdout, "\nLog:\n\n")
+	stream := tsuruIo.NewStreamWriter(context.Stdout, deployLogFormatter{})
+	if _, err := io.WriteString(stream, deploy.Log); err != nil {
+		return err
+	}
+	if !strings.HasSuffix(deploy.Log, "\n") {
+		fmt.Fprintln(context.Stdout)
 	}
 	return nil
 }
+
+// deployLogFormatter formats the log of past deploys, as stored by the
+// server. Lines may be JSON messages, like the ones streamed during the
+// deploy, or plain text. Errors and failed steps are colored red.
+type deployLogFormatter struct{}
+
+func (deployLogFormatter) Format(out io.Writer, data []byte) error {
+	var msg tsuruIo.SimpleJsonMessage
+	if err := json.Unmarshal(data, &msg); err == nil {
+		if msg.Error != "" {
+			_, err = fmt.Fprintln(out, cmd.Colorfy(msg.Error, "red", "", ""))
+			return err
+		}
+		_, err = io.WriteString(out, msg.Message)
+		return err
+	}
+	line := strings.TrimRight(string(data), "\n")
+	if failedStep(line) {
+		line = cmd.Colorfy(line, "red", "", "")
+	}
+	if bytes.HasSuffix(data, []byte("\n")) {
+		line += "\n"
+	}
+	_, err := io.WriteString(out, line)
+	return err
+}
+
+// failedStep tells whether a plain text line of a deploy log reports a
+// failure.
+func failedStep(line string) bool {
+	line = strings.ToLower(strings.TrimSpace(line))
+	line = strings.TrimLeft(line, "-> ")
+	for _, prefix := range []string{"error", "fatal", "failed"} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return strings.Contains(line, " failed:") || strings.Contains(line, "exit status")
+}
+
+// findDeploy returns the deploy with the given ID or, when ref isn't an ID,
+// the last deploy of the app with the given image or image tag.
+func findDeploy(client *cmd.Client, g *cmd.GuessingCommand, ref string) (*tsuruapp.DeployData, error) {
+	if bson.IsObjectIdHex(ref) {
+		return getDeploy(client, ref)
+	}
+	appName, err := g.Guess()
+	if err != nil {
+		return nil, err
+	}
+	deploys, err := listDeploys(client, appName, 0, deployListBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	for i := range deploys {
+		if image := deploys[i].Image; image == ref || strings.HasSuffix(image, ":"+ref) {
+			return &deploys[i], nil
+		}
+	}
+	return nil, fmt.Errorf("deploy %q not found in the last %d deploys of app %q", ref, deployListBatchSize, appName)
+}
+
+// getDeploy returns the deploy with the given ID.
+func getDeploy(client *cmd.Client, id string) (*tsuruapp.DeployData, error) {
+	api, err := apiClient(client)
+	if err != nil {
+		return nil, err
+	}
+	return api.GetDeploy(id)
+}
+
+// deployDuration formats the duration of a deploy as minutes and seconds.
+func deployDuration(d time.Duration) string {
+	seconds := d / time.Second
+	minutes := seconds / 60
+	seconds = seconds % 60
+	return fmt.Sprintf("%02d:%02d", minutes, seconds)
+}
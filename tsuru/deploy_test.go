@@ -8,16 +8,28 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
 	"sort"
+	"strings"
 	"time"
 
+	tsuruapp "github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/cmd"
 	"github.com/tsuru/tsuru/cmd/cmdtest"
+	"github.com/tsuru/tsuru/exec"
+	"github.com/tsuru/tsuru/exec/exectest"
+	"github.com/tsuru/tsuru/fs/fstest"
 	tsuruIo "github.com/tsuru/tsuru/io"
+	"gopkg.in/mgo.v2/bson"
 	"launchpad.net/gocheck"
 )
 
@@ -26,12 +38,63 @@ func (s *S) TestDeployInfo(c *gocheck.C) {
 
 tsuru app-deploy .
 tsuru app-deploy myfile.jar Procfile
+tsuru app-deploy --image registry.example.com/myapp:v1
+
+Files inside the given directories are skipped when they match a pattern in the
+.tsuruignore file of the current directory, which uses the .gitignore syntax,
+or a pattern given with --exclude. Use --dry-run to list the files that would
+be deployed and the size of the archive, without deploying.
+
+Files are archived with their paths relative to the current directory, which
+is the root of the project: paths outside of it are rejected. Symlinks inside
+directories are archived as symlinks, and must point to files inside the
+project, unless --follow-symlinks is used to archive the files they point to.
+
+The SHA-256 digest of the uncompressed archive is printed before the upload.
+With --reproducible, the archive depends only on the names, contents and
+permissions of the files, so identical trees always have the same digest.
+
+With --skip-if-unchanged, the archive is always reproducible and its digest is
+recorded in ~/.tsuru after a successful deploy. When the digest of a later
+deploy matches the recorded one, and nobody deployed the app since then, the
+deploy is skipped and app-deploy exits with status 6.
+
+When the upload fails before the build starts, it's retried up to --retries
+times, waiting longer after each failure.
+
+With --image, no files are sent: the server deploys the given Docker image,
+which must be available in a registry reachable by the tsuru nodes. The flags
+that change the archive, --exclude, --reproducible, --skip-if-unchanged and
+--follow-symlinks, can't be used with it.
+
+Hooks can be declared in the .tsuru.yaml file of the project, found in the
+current directory or in its parents, as lists of shell commands under
+hooks/pre-deploy and hooks/post-deploy, which run in the directory of the file:
+
+hooks:
+  pre-deploy:
+    - make test
+  post-deploy:
+    - ./notify.sh
+
+Pre-deploy hooks run before the archive is built, and the deploy is aborted
+when any of them fails. With --skip-if-unchanged, the files are compared before
+the hooks run, and skipped deploys don't run them. Post-deploy hooks run after
+the deploy finishes, with the environment variables TSURU_APP_NAME,
+TSURU_DEPLOY_RESULT (success or failure), TSURU_DEPLOY_IMAGE and
+TSURU_DEPLOY_ERROR. TSURU_DEPLOY_IMAGE is empty when the image isn't known,
+like when another deploy of the app finished at the same time. Use --no-hooks
+to skip them.
+
+The exit status tells why a deploy failed: 3 for build failures, 4 for upload
+failures and 5 for failed healthchecks. Other errors exit with status 1. The
+server doesn't tell the kind of a failure, so errors that mention a
+healthcheck are taken as failed healthchecks.
 `
 	expected := &cmd.Info{
-		Name:    "app-deploy",
-		Usage:   "app-deploy [-a/--app <appname>] <file-or-dir-1> [file-or-dir-2] ... [file-or-dir-n]",
-		Desc:    desc,
-		MinArgs: 1,
+		Name:  "app-deploy",
+		Usage: "app-deploy [-a/--app <appname>] [--exclude <pattern>] [--dry-run] [--retries <n>] [--reproducible] [--follow-symlinks] [--skip-if-unchanged] [--no-hooks] <file-or-dir-1> [file-or-dir-2] ... [file-or-dir-n] | --image <image>",
+		Desc:  desc,
 	}
 	var cmd appDeploy
 	c.Assert(cmd.Info(), gocheck.DeepEquals, expected)
@@ -40,7 +103,7 @@ tsuru app-deploy myfile.jar Procfile
 func (s *S) TestDeployRun(c *gocheck.C) {
 	var called bool
 	var buf bytes.Buffer
-	err := targz(nil, &buf, "testdata")
+	err := targz(nil, &buf, nil, "testdata")
 	c.Assert(err, gocheck.IsNil)
 	trans := cmdtest.ConditionalTransport{
 		Transport: cmdtest.Transport{Message: "deploy worked\nOK\n", Status: http.StatusOK},
@@ -70,6 +133,75 @@ func (s *S) TestDeployRun(c *gocheck.C) {
 	c.Assert(called, gocheck.Equals, true)
 }
 
+func (s *S) TestDeployRunStreamsArchive(c *gocheck.C) {
+	var buf bytes.Buffer
+	err := targz(nil, &buf, nil, "testdata")
+	c.Assert(err, gocheck.IsNil)
+	trans := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "deploy worked\nOK\n", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			defer req.Body.Close()
+			c.Assert(req.ContentLength, gocheck.Equals, int64(-1))
+			reader, err := req.MultipartReader()
+			c.Assert(err, gocheck.IsNil)
+			part, err := reader.NextPart()
+			c.Assert(err, gocheck.IsNil)
+			c.Assert(part.FormName(), gocheck.Equals, "file")
+			content, err := ioutil.ReadAll(part)
+			c.Assert(err, gocheck.IsNil)
+			c.Assert(content, gocheck.DeepEquals, buf.Bytes())
+			return true
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Args:   []string{"testdata"},
+	}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}}
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+}
+
+func (s *S) TestDeployRunDryRun(c *gocheck.C) {
+	dir := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(dir, "node_modules", "lib"), 0755), gocheck.IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(dir, "src"), 0755), gocheck.IsNil)
+	files := map[string]string{
+		".tsuruignore":            "node_modules/\n*.log\n",
+		".env":                    "SECRET=1\n",
+		"Procfile":                "web: ./app\n",
+		"debug.log":               "debug\n",
+		"node_modules/lib/lib.js": "lib\n",
+		"src/main.go":             "package main\n",
+	}
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		c.Assert(err, gocheck.IsNil)
+	}
+	wd, err := os.Getwd()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(os.Chdir(dir), gocheck.IsNil)
+	defer os.Chdir(wd)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	fake := cmdtest.FakeGuesser{Name: "secret"}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &fake}}
+	err = command.Flags().Parse(true, []string{"--dry-run", "--exclude", ".env", "."})
+	c.Assert(err, gocheck.IsNil)
+	context.Args = command.Flags().Args()
+	err = command.Run(&context, nil)
+	c.Assert(err, gocheck.IsNil)
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	c.Assert(lines, gocheck.HasLen, 6)
+	c.Assert(lines[:3], gocheck.DeepEquals, []string{".tsuruignore", "Procfile", "src/main.go"})
+	c.Assert(lines[3], gocheck.Equals, "")
+	c.Assert(lines[4], gocheck.Matches, `3 files, archive size: \d+ B`)
+	c.Assert(lines[5], gocheck.Matches, `Archive digest: sha256:[0-9a-f]{64}`)
+}
+
 func (s *S) TestDeployRunNotOK(c *gocheck.C) {
 	trans := cmdtest.Transport{Message: "deploy worked\n", Status: http.StatusOK}
 	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
@@ -83,7 +215,72 @@ func (s *S) TestDeployRunNotOK(c *gocheck.C) {
 	guessCommand := cmd.GuessingCommand{G: &fake}
 	command := appDeploy{GuessingCommand: guessCommand}
 	err := command.Run(&context, client)
-	c.Assert(err, gocheck.Equals, cmd.ErrAbortCommand)
+	c.Assert(err, gocheck.ErrorMatches, "build failed: the server didn't report a successful deploy")
+	c.Assert(exitr.(*recordingExiter).codes, gocheck.DeepEquals, []int{exitDeployBuildFailure})
+}
+
+func (s *S) TestDeployRunJSONStream(c *gocheck.C) {
+	var messages string
+	for _, m := range []string{"building\n", "OK\n"} {
+		data, err := json.Marshal(tsuruIo.SimpleJsonMessage{Message: m})
+		c.Assert(err, gocheck.IsNil)
+		messages += string(data) + "\n"
+	}
+	trans := cmdtest.Transport{
+		Message: messages,
+		Status:  http.StatusOK,
+		Headers: map[string][]string{"Content-Type": {"application/x-json-stream"}},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Args:   []string{"testdata"},
+	}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Matches, `(?s).* ok\nbuilding\nOK\n$`)
+	c.Assert(exitr.(*recordingExiter).codes, gocheck.HasLen, 0)
+}
+
+func (s *S) TestDeployRunJSONStreamFailures(c *gocheck.C) {
+	var tests = []struct {
+		err   string
+		phase string
+		code  int
+	}{
+		{"exit status 1", deployPhaseBuild, exitDeployBuildFailure},
+		{"healthcheck fail(/status): wrong status code, expected 200, got: 500", deployPhaseHealthcheck, exitDeployHealthcheckFailure},
+	}
+	for _, t := range tests {
+		progress, err := json.Marshal(tsuruIo.SimpleJsonMessage{Message: "building\n"})
+		c.Assert(err, gocheck.IsNil)
+		failure, err := json.Marshal(tsuruIo.SimpleJsonMessage{Error: t.err})
+		c.Assert(err, gocheck.IsNil)
+		trans := cmdtest.Transport{
+			Message: string(progress) + "\n" + string(failure) + "\n",
+			Status:  http.StatusOK,
+			Headers: map[string][]string{"Content-Type": {"application/x-json-stream"}},
+		}
+		client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+		var stdout, stderr bytes.Buffer
+		context := cmd.Context{
+			Stdout: &stdout,
+			Stderr: &stderr,
+			Args:   []string{"testdata"},
+		}
+		exiter := recordingExiter{}
+		exitr = &exiter
+		command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}}
+		err = command.Run(&context, client)
+		c.Assert(err, gocheck.NotNil)
+		c.Assert(err.(*deployError).phase, gocheck.Equals, t.phase)
+		c.Assert(err.Error(), gocheck.Equals, t.phase+" failed: "+t.err)
+		c.Assert(stderr.String(), gocheck.Equals, "Error: "+t.phase+" failed: "+t.err+"\n")
+		c.Assert(exiter.codes, gocheck.DeepEquals, []int{t.code})
+	}
 }
 
 func (s *S) TestDeployRunFileNotFound(c *gocheck.C) {
@@ -112,16 +309,332 @@ func (s *S) TestDeployRunRequestFailure(c *gocheck.C) {
 	fake := cmdtest.FakeGuesser{Name: "secret"}
 	guessCommand := cmd.GuessingCommand{G: &fake}
 	command := appDeploy{GuessingCommand: guessCommand}
+	command.retries = 3
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err.Error(), gocheck.Equals, "upload failed: app not found\n")
+	e, ok := err.(*deployError)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.phase, gocheck.Equals, deployPhaseUpload)
+	c.Assert(stderr.String(), gocheck.Equals, "Error: upload failed: app not found\n")
+	c.Assert(exitr.(*recordingExiter).codes, gocheck.DeepEquals, []int{exitDeployUploadFailure})
+}
+
+func (s *S) TestDeployRunRetriesInterruptedUpload(c *gocheck.C) {
+	old := uploadRetryDelay
+	uploadRetryDelay = time.Millisecond
+	defer func() { uploadRetryDelay = old }()
+	trans := cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{},
+				CondFunc:  func(req *http.Request) bool { return false },
+			},
+			{
+				Transport: cmdtest.Transport{Message: "unavailable", Status: http.StatusServiceUnavailable},
+				CondFunc:  func(req *http.Request) bool { return true },
+			},
+			{
+				Transport: cmdtest.Transport{Message: "deploy worked\nOK\n", Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					_, _, err := req.FormFile("file")
+					return err == nil
+				},
+			},
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Args:   []string{"testdata"},
+	}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}}
+	command.retries = 2
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(trans.ConditionalTransports, gocheck.HasLen, 0)
+	c.Assert(strings.Count(stderr.String(), "Retrying in"), gocheck.Equals, 2)
+	c.Assert(stderr.String(), gocheck.Matches, `(?s).*\(2 of 2\)\.\.\.\n$`)
+}
+
+func (s *S) TestDeployRunDoesntRetryAfterCompleteUpload(c *gocheck.C) {
+	old := uploadRetryDelay
+	uploadRetryDelay = time.Millisecond
+	defer func() { uploadRetryDelay = old }()
+	var attempts int
+	trans := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "gateway timeout", Status: http.StatusGatewayTimeout},
+		CondFunc: func(req *http.Request) bool {
+			attempts++
+			_, err := ioutil.ReadAll(req.Body)
+			return err == nil
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Args:   []string{"testdata"},
+	}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}}
+	command.retries = 2
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(attempts, gocheck.Equals, 1)
+	c.Assert(stderr.String(), gocheck.Not(gocheck.Matches), "(?s).*Retrying in.*")
+	c.Assert(exitr.(*recordingExiter).codes, gocheck.DeepEquals, []int{exitDeployUploadFailure})
+}
+
+func (s *S) TestDeployRunGivesUpAfterRetries(c *gocheck.C) {
+	old := uploadRetryDelay
+	uploadRetryDelay = time.Millisecond
+	defer func() { uploadRetryDelay = old }()
+	trans := cmdtest.Transport{Message: "unavailable", Status: http.StatusBadGateway}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Args:   []string{"testdata"},
+	}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}}
+	command.retries = 1
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.ErrorMatches, "upload failed: unavailable")
+	c.Assert(strings.Count(stderr.String(), "Retrying in"), gocheck.Equals, 1)
+}
+
+func (s *S) TestDeployRunArchivingFailure(c *gocheck.C) {
+	trans := cmdtest.Transport{Message: "", Status: http.StatusOK}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Args:   []string{"/tmp/something/that/doesnt/really/exist/im/sure"},
+	}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}}
 	err := command.Run(&context, client)
 	c.Assert(err, gocheck.NotNil)
-	c.Assert(err.Error(), gocheck.Equals, "app not found\n")
+	c.Assert(err.(*deployError).phase, gocheck.Equals, deployPhaseArchiving)
+}
+
+func (s *S) TestDeployRunSkipIfUnchanged(c *gocheck.C) {
+	rfs := fstest.RecordingFs{}
+	fsystem = &rfs
+	defer func() {
+		fsystem = nil
+	}()
+	digest, _, err := archiveDigest(&archiveOptions{reproducible: true}, "testdata")
+	c.Assert(err, gocheck.IsNil)
+	cache := deployCache{}
+	cache.set("http://localhost:8080", "secret", deployCacheEntry{Digest: digest, Deploy: "54c92d91a46ec0e78501d86b"})
+	c.Assert(cache.save(), gocheck.IsNil)
+	trans := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: `[{"ID": "54c92d91a46ec0e78501d86b", "App": "secret"}]`, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.Method == "GET" && req.URL.Path == "/deploys" &&
+				req.URL.Query().Get("app") == "secret" && req.URL.Query().Get("limit") == "1"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Args:   []string{"testdata"},
+	}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}, skip: true}
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(exitr.(*recordingExiter).codes, gocheck.DeepEquals, []int{exitDeployUnchanged})
+	expected := "Archive digest: " + digest + "\nThe archive didn't change since the last deploy of app \"secret\", skipping.\n"
+	c.Assert(stdout.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestDeployRunSkipIfUnchangedDeployedByOthers(c *gocheck.C) {
+	rfs := fstest.RecordingFs{}
+	fsystem = &rfs
+	defer func() {
+		fsystem = nil
+	}()
+	digest, _, err := archiveDigest(&archiveOptions{reproducible: true}, "testdata")
+	c.Assert(err, gocheck.IsNil)
+	cache := deployCache{}
+	cache.set("http://localhost:8080", "secret", deployCacheEntry{Digest: digest, Deploy: "54c92d91a46ec0e78501d86b"})
+	c.Assert(cache.save(), gocheck.IsNil)
+	var uploaded bool
+	trans := cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: `[{"ID": "54c922d0a46ec0e78501d84e", "App": "secret"}]`, Status: http.StatusOK},
+				CondFunc:  func(req *http.Request) bool { return req.URL.Path == "/deploys" },
+			},
+			{
+				Transport: cmdtest.Transport{Message: "deploy worked\nOK\n", Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					uploaded = true
+					return req.URL.Path == "/apps/secret/deploy"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: `[{"ID": "54c918a7a46ec0e78501d831", "App": "secret"}]`, Status: http.StatusOK},
+				CondFunc:  func(req *http.Request) bool { return req.URL.Path == "/deploys" },
+			},
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Args:   []string{"testdata"},
+	}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}, skip: true}
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(uploaded, gocheck.Equals, true)
+	c.Assert(exitr.(*recordingExiter).codes, gocheck.HasLen, 0)
+	cache, err = loadDeployCache()
+	c.Assert(err, gocheck.IsNil)
+	entry, ok := cache.get("http://localhost:8080", "secret")
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(entry, gocheck.DeepEquals, deployCacheEntry{Digest: digest, Deploy: "54c918a7a46ec0e78501d831"})
+}
+
+func (s *S) TestDeployRunSkipIfUnchangedFirstDeploy(c *gocheck.C) {
+	rfs := fstest.RecordingFs{}
+	fsystem = &rfs
+	defer func() {
+		fsystem = nil
+	}()
+	trans := cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: "deploy worked\nOK\n", Status: http.StatusOK},
+				CondFunc:  func(req *http.Request) bool { return req.URL.Path == "/apps/secret/deploy" },
+			},
+			{
+				Transport: cmdtest.Transport{Message: `[{"ID": "54c918a7a46ec0e78501d831", "App": "secret"}]`, Status: http.StatusOK},
+				CondFunc:  func(req *http.Request) bool { return req.URL.Path == "/deploys" },
+			},
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Args:   []string{"testdata"},
+	}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}, skip: true}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(trans.ConditionalTransports, gocheck.HasLen, 0)
+	c.Assert(rfs.HasAction("openfile "+cmd.JoinWithUserDir(".tsuru", "deploys.json")+" with mode 0600"), gocheck.Equals, true)
+	cache, err := loadDeployCache()
+	c.Assert(err, gocheck.IsNil)
+	entry, _ := cache.get("http://localhost:8080", "secret")
+	c.Assert(entry.Deploy, gocheck.Equals, "54c918a7a46ec0e78501d831")
+}
+
+func (s *S) TestDeployRunImage(c *gocheck.C) {
+	var called bool
+	msg := tsuruIo.SimpleJsonMessage{Message: "-- deployed --"}
+	result, err := json.Marshal(msg)
+	c.Assert(err, gocheck.IsNil)
+	trans := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: string(result), Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			return req.Method == "POST" && req.URL.Path == "/apps/secret/deploy" &&
+				req.Header.Get("Content-Type") == "application/x-www-form-urlencoded" &&
+				req.FormValue("image") == "registry.example.com/myapp:v1"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}}
+	err = command.Flags().Parse(true, []string{"--image", "registry.example.com/myapp:v1"})
+	c.Assert(err, gocheck.IsNil)
+	context.Args = command.Flags().Args()
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+	c.Assert(stdout.String(), gocheck.Equals, "-- deployed --")
+}
+
+func (s *S) TestDeployRunImageFailure(c *gocheck.C) {
+	msg := tsuruIo.SimpleJsonMessage{Message: "pulling image\n"}
+	result, err := json.Marshal(msg)
+	c.Assert(err, gocheck.IsNil)
+	msg = tsuruIo.SimpleJsonMessage{Error: "image not found"}
+	errResult, err := json.Marshal(msg)
+	c.Assert(err, gocheck.IsNil)
+	trans := cmdtest.Transport{Message: string(result) + "\n" + string(errResult) + "\n", Status: http.StatusOK}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}, image: "myapp:v2"}
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.ErrorMatches, "build failed: image not found")
+	c.Assert(err.(*deployError).phase, gocheck.Equals, deployPhaseBuild)
+	c.Assert(stdout.String(), gocheck.Equals, "pulling image\n")
+}
+
+func (s *S) TestDeployRunImageWithFiles(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr, Args: []string{"testdata"}}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}, image: "myapp:v2"}
+	err := command.Run(&context, nil)
+	c.Assert(err, gocheck.ErrorMatches, "You can't deploy files or use --dry-run when deploying an image.")
+}
+
+func (s *S) TestDeployRunImageWithArchiveFlags(c *gocheck.C) {
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--exclude", "*.log"}, "You can't use --exclude when deploying an image, as no files are archived."},
+		{[]string{"--reproducible"}, "You can't use --reproducible when deploying an image, as no files are archived."},
+		{[]string{"--skip-if-unchanged"}, "You can't use --skip-if-unchanged when deploying an image, as no files are archived."},
+		{[]string{"--follow-symlinks"}, "You can't use --follow-symlinks when deploying an image, as no files are archived."},
+		{
+			[]string{"--reproducible", "--follow-symlinks"},
+			"You can't use --reproducible, --follow-symlinks when deploying an image, as no files are archived.",
+		},
+	}
+	for _, t := range tests {
+		var stdout, stderr bytes.Buffer
+		context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+		command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}}
+		err := command.Flags().Parse(true, append([]string{"--image", "myapp:v2"}, t.args...))
+		c.Assert(err, gocheck.IsNil)
+		err = command.Run(&context, nil)
+		c.Check(err, gocheck.NotNil)
+		if err != nil {
+			c.Check(err.Error(), gocheck.Equals, t.expected)
+		}
+	}
+}
+
+func (s *S) TestDeployRunWithoutFiles(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}}
+	err := command.Run(&context, nil)
+	c.Assert(err, gocheck.ErrorMatches, "You should provide at least one file or directory to deploy, or an image with --image.")
 }
 
 func (s *S) TestTargz(c *gocheck.C) {
 	var buf bytes.Buffer
 	ctx := cmd.Context{Stderr: &buf}
 	var gzipBuf, tarBuf bytes.Buffer
-	err := targz(&ctx, &gzipBuf, "testdata", "..")
+	err := targz(&ctx, &gzipBuf, nil, "testdata", "..")
 	c.Assert(err, gocheck.IsNil)
 	gzipReader, err := gzip.NewReader(&gzipBuf)
 	c.Assert(err, gocheck.IsNil)
@@ -152,21 +665,232 @@ func (s *S) TestTargz(c *gocheck.C) {
 	c.Assert(buf.String(), gocheck.Equals, `Warning: skipping ".."`)
 }
 
+func (s *S) TestTargzSortsEntries(c *gocheck.C) {
+	var visited []string
+	opts := archiveOptions{visit: func(path string, fi os.FileInfo) {
+		visited = append(visited, path)
+	}}
+	err := targz(nil, ioutil.Discard, &opts, "testdata")
+	c.Assert(err, gocheck.IsNil)
+	expected := []string{
+		"testdata", "testdata/directory", "testdata/directory/file.txt",
+		"testdata/file1.txt", "testdata/file2.txt",
+	}
+	c.Assert(visited, gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestTargzReproducible(c *gocheck.C) {
+	dir := c.MkDir()
+	c.Assert(os.Mkdir(filepath.Join(dir, "bin"), 0700), gocheck.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "bin", "run"), []byte("#!/bin/sh\n"), 0700), gocheck.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "Procfile"), []byte("web: bin/run\n"), 0600), gocheck.IsNil)
+	opts := archiveOptions{root: dir, reproducible: true}
+	var first, second bytes.Buffer
+	err := targz(nil, &first, &opts, dir)
+	c.Assert(err, gocheck.IsNil)
+	later := time.Now().Add(time.Hour)
+	for _, name := range []string{"bin/run", "Procfile", "bin"} {
+		c.Assert(os.Chtimes(filepath.Join(dir, name), later, later), gocheck.IsNil)
+	}
+	err = targz(nil, &second, &opts, dir)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(first.Bytes(), gocheck.DeepEquals, second.Bytes())
+	gzipReader, err := gzip.NewReader(&first)
+	c.Assert(err, gocheck.IsNil)
+	tarReader := tar.NewReader(gzipReader)
+	modes := map[string]int64{}
+	for header, err := tarReader.Next(); err == nil; header, err = tarReader.Next() {
+		c.Check(header.ModTime.Unix(), gocheck.Equals, int64(0))
+		c.Check(header.Uid, gocheck.Equals, 0)
+		c.Check(header.Gid, gocheck.Equals, 0)
+		c.Check(header.Uname, gocheck.Equals, "")
+		modes[header.Name] = header.Mode
+	}
+	c.Assert(modes, gocheck.DeepEquals, map[string]int64{".": 0755, "Procfile": 0644, "bin": 0755, "bin/run": 0755})
+}
+
+func tarHeaders(c *gocheck.C, archive []byte) map[string]*tar.Header {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(archive))
+	c.Assert(err, gocheck.IsNil)
+	tarReader := tar.NewReader(gzipReader)
+	headers := map[string]*tar.Header{}
+	for header, err := tarReader.Next(); err != io.EOF; header, err = tarReader.Next() {
+		c.Assert(err, gocheck.IsNil)
+		headers[header.Name] = header
+	}
+	return headers
+}
+
+func (s *S) TestTargzNormalizesNames(c *gocheck.C) {
+	dir := c.MkDir()
+	c.Assert(os.Mkdir(filepath.Join(dir, "src"), 0755), gocheck.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "src", "main.go"), []byte("package main\n"), 0644), gocheck.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "Procfile"), []byte("web: ./app\n"), 0644), gocheck.IsNil)
+	var buf bytes.Buffer
+	opts := archiveOptions{root: dir}
+	err := targz(nil, &buf, &opts, filepath.Join(dir, "src"), dir+"/src/../Procfile")
+	c.Assert(err, gocheck.IsNil)
+	var names []string
+	for name := range tarHeaders(c, buf.Bytes()) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c.Assert(names, gocheck.DeepEquals, []string{"Procfile", "src", "src/main.go"})
+}
+
+func (s *S) TestTargzRejectsPathsOutsideRoot(c *gocheck.C) {
+	dir := c.MkDir()
+	c.Assert(os.Mkdir(filepath.Join(dir, "project"), 0755), gocheck.IsNil)
+	c.Assert(os.Mkdir(filepath.Join(dir, "other"), 0755), gocheck.IsNil)
+	opts := archiveOptions{root: filepath.Join(dir, "project")}
+	err := targz(nil, ioutil.Discard, &opts, filepath.Join(dir, "project", "..", "other"))
+	c.Assert(err, gocheck.ErrorMatches, ".*/other is outside of the project root, .*/project")
+}
+
+func (s *S) TestTargzKeepsSymlinks(c *gocheck.C) {
+	dir := c.MkDir()
+	c.Assert(os.Mkdir(filepath.Join(dir, "releases"), 0755), gocheck.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "releases", "app.jar"), []byte("jar"), 0644), gocheck.IsNil)
+	c.Assert(os.Symlink("releases/app.jar", filepath.Join(dir, "app.jar")), gocheck.IsNil)
+	c.Assert(os.Symlink(".", filepath.Join(dir, "releases", "self")), gocheck.IsNil)
+	var buf bytes.Buffer
+	opts := archiveOptions{root: dir}
+	err := targz(nil, &buf, &opts, dir)
+	c.Assert(err, gocheck.IsNil)
+	headers := tarHeaders(c, buf.Bytes())
+	c.Assert(headers, gocheck.HasLen, 5)
+	c.Assert(headers["app.jar"].Typeflag, gocheck.Equals, byte(tar.TypeSymlink))
+	c.Assert(headers["app.jar"].Linkname, gocheck.Equals, "releases/app.jar")
+	c.Assert(headers["releases/self"].Typeflag, gocheck.Equals, byte(tar.TypeSymlink))
+	c.Assert(headers["releases/self"].Linkname, gocheck.Equals, ".")
+}
+
+func (s *S) TestTargzRejectsSymlinksOutsideRoot(c *gocheck.C) {
+	for _, target := range []string{"../outside", "/etc/passwd"} {
+		dir := c.MkDir()
+		c.Assert(os.Mkdir(filepath.Join(dir, "project"), 0755), gocheck.IsNil)
+		c.Assert(os.Symlink(target, filepath.Join(dir, "project", "link")), gocheck.IsNil)
+		opts := archiveOptions{root: filepath.Join(dir, "project")}
+		err := targz(nil, ioutil.Discard, &opts, filepath.Join(dir, "project"))
+		c.Assert(err, gocheck.ErrorMatches, "symlink .*/link points to "+target+", outside of the project root.*")
+	}
+}
+
+func (s *S) TestTargzFollowSymlinks(c *gocheck.C) {
+	dir := c.MkDir()
+	shared := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(shared, "lib.py"), []byte("x = 1\n"), 0644), gocheck.IsNil)
+	c.Assert(os.Symlink(shared, filepath.Join(dir, "lib")), gocheck.IsNil)
+	var buf bytes.Buffer
+	opts := archiveOptions{root: dir, followSymlinks: true}
+	err := targz(nil, &buf, &opts, dir)
+	c.Assert(err, gocheck.IsNil)
+	headers := tarHeaders(c, buf.Bytes())
+	c.Assert(headers, gocheck.HasLen, 3)
+	c.Assert(headers["lib"].Typeflag, gocheck.Equals, byte(tar.TypeDir))
+	c.Assert(headers["lib/lib.py"].Typeflag, gocheck.Equals, byte(tar.TypeReg))
+}
+
+func (s *S) TestTargzFollowSymlinksCycle(c *gocheck.C) {
+	dir := c.MkDir()
+	c.Assert(os.Mkdir(filepath.Join(dir, "a"), 0755), gocheck.IsNil)
+	c.Assert(os.Symlink("..", filepath.Join(dir, "a", "loop")), gocheck.IsNil)
+	opts := archiveOptions{root: dir, followSymlinks: true}
+	err := targz(nil, ioutil.Discard, &opts, dir)
+	c.Assert(err, gocheck.ErrorMatches, "symlink cycle: .*/a/loop is .*, which is one of its parent directories")
+}
+
+func (s *S) TestArchiveDigest(c *gocheck.C) {
+	dir := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "Procfile"), []byte("web: ./app\n"), 0644), gocheck.IsNil)
+	opts := archiveOptions{root: dir, reproducible: true}
+	digest, size, err := archiveDigest(&opts, dir)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(digest, gocheck.Matches, "sha256:[0-9a-f]{64}")
+	c.Assert(size, gocheck.Equals, int64(11))
+	later := time.Now().Add(time.Hour)
+	c.Assert(os.Chtimes(filepath.Join(dir, "Procfile"), later, later), gocheck.IsNil)
+	again, _, err := archiveDigest(&opts, dir)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(again, gocheck.Equals, digest)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "Procfile"), []byte("web: ./other\n"), 0644), gocheck.IsNil)
+	changed, _, err := archiveDigest(&opts, dir)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(changed, gocheck.Not(gocheck.Equals), digest)
+	var tarBuf bytes.Buffer
+	var gzipBuf bytes.Buffer
+	opts.digest = &tarBuf
+	err = targz(nil, &gzipBuf, &opts, dir)
+	c.Assert(err, gocheck.IsNil)
+	sum := sha256.Sum256(tarBuf.Bytes())
+	c.Assert(changed, gocheck.Equals, "sha256:"+hex.EncodeToString(sum[:]))
+}
+
+func (s *S) TestTargzIgnore(c *gocheck.C) {
+	var ignore ignoreList
+	ignore.add("*.txt")
+	ignore.add("!file1.txt")
+	var gzipBuf, tarBuf bytes.Buffer
+	err := targz(nil, &gzipBuf, &archiveOptions{ignore: ignore}, "testdata")
+	c.Assert(err, gocheck.IsNil)
+	gzipReader, err := gzip.NewReader(&gzipBuf)
+	c.Assert(err, gocheck.IsNil)
+	_, err = io.Copy(&tarBuf, gzipReader)
+	c.Assert(err, gocheck.IsNil)
+	tarReader := tar.NewReader(&tarBuf)
+	var headers []string
+	for header, err := tarReader.Next(); err == nil; header, err = tarReader.Next() {
+		headers = append(headers, header.Name)
+	}
+	sort.Strings(headers)
+	c.Assert(headers, gocheck.DeepEquals, []string{"testdata", "testdata/directory", "testdata/file1.txt"})
+}
+
+func (s *S) TestTargzIgnoreDoesNotSkipArguments(c *gocheck.C) {
+	var ignore ignoreList
+	ignore.add("*.txt")
+	var visited []string
+	opts := archiveOptions{
+		ignore: ignore,
+		visit: func(path string, fi os.FileInfo) {
+			visited = append(visited, path)
+		},
+	}
+	err := targz(nil, ioutil.Discard, &opts, "testdata/file2.txt", "testdata/directory")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(visited, gocheck.DeepEquals, []string{"testdata/file2.txt", "testdata/directory"})
+}
+
 func (s *S) TestTargzFailure(c *gocheck.C) {
 	var stderr bytes.Buffer
 	ctx := cmd.Context{Stderr: &stderr}
 	var buf bytes.Buffer
-	err := targz(&ctx, &buf, "/tmp/something/that/definitely/doesnt/exist/right", "testdata")
+	err := targz(&ctx, &buf, nil, "/tmp/something/that/definitely/doesnt/exist/right", "testdata")
 	c.Assert(err, gocheck.NotNil)
 	c.Assert(err.Error(), gocheck.Equals, "stat /tmp/something/that/definitely/doesnt/exist/right: no such file or directory")
 }
 
 func (s *S) TestDeployListInfo(c *gocheck.C) {
+	desc := `List information about deploys for an application, from the newest to the
+oldest.
+
+By default, the last 10 deploys are listed. Use --limit to change the number
+of deploys, and --page or --skip to see older ones. Deploys may be filtered by
+the user who made them, by their origin (git, rollback, upload or image), by
+their date, with --since and --until, and by their status (success or
+failure). Dates are given like 2015-01-28 or 2015-01-28 15:04, or as a
+duration before now, like 12h or 7d. As the filters are applied by the
+client, only the newest 2000 deploys are searched.
+
+Use --all-apps to list the deploys of all apps you have access to. To be read
+by scripts, deploys may be printed with --json, --yaml, or --format, which
+takes a Go template, like '{{.Image}} {{.User}}'. --quiet prints only their
+IDs.
+`
 	expected := &cmd.Info{
-		Name:    "app-deploy-list",
-		Usage:   "app-deploy-list [-a/--app <appname>]",
-		Desc:    "List information about deploys for an application.",
-		MinArgs: 0,
+		Name:  "app-deploy-list",
+		Usage: "app-deploy-list [-a/--app <appname> | --all-apps] [-l/--limit <n>] [--page <n> | --skip <n>] [--user <email>] [--origin <origin>] [--since <date>] [--until <date>] [--status <success|failure>] [--json | --yaml | --format <template> | -q/--quiet]",
+		Desc:  desc,
 	}
 	var cmd appDeployList
 	c.Assert(cmd.Info(), gocheck.DeepEquals, expected)
@@ -247,12 +971,164 @@ func (s *S) TestAppDeployList(c *gocheck.C) {
 	c.Assert(stdout.String(), gocheck.Equals, expected)
 }
 
-func (s *S) TestAppDeployRollbackInfo(c *gocheck.C) {
+// deploysJSON renders deploys as returned by the server, dated one hour
+// apart from 2015-01-28 18:00 UTC, in the given order.
+func deploysJSON(c *gocheck.C, deploys ...tsuruapp.DeployData) string {
+	start := time.Date(2015, 1, 28, 18, 0, 0, 0, time.UTC)
+	for i := range deploys {
+		deploys[i].Timestamp = start.Add(-time.Duration(i) * time.Hour)
+	}
+	data, err := json.Marshal(deploys)
+	c.Assert(err, gocheck.IsNil)
+	return string(data)
+}
+
+func (s *S) TestAppDeployListPagination(c *gocheck.C) {
+	var deploys []tsuruapp.DeployData
+	for i := 0; i < 3; i++ {
+		deploys = append(deploys, tsuruapp.DeployData{App: "test", Image: fmt.Sprintf("tsuru/app-test:v%d", 10-i), Origin: "app-deploy"})
+	}
+	trans := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: deploysJSON(c, deploys...), Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			query := req.URL.Query()
+			return req.URL.Path == "/deploys" && query.Get("app") == "test" &&
+				query.Get("limit") == "3" && query.Get("skip") == "4"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	command := appDeployList{}
+	err := command.Flags().Parse(true, []string{"-a", "test", "-l", "2", "--page", "3"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(strings.Count(stdout.String(), "tsuru/app-test:"), gocheck.Equals, 2)
+	c.Assert(stdout.String(), gocheck.Not(gocheck.Matches), "(?s).*tsuru/app-test:v8.*")
+	c.Assert(stdout.String(), gocheck.Matches, "(?s).*\nUse --page 4 to see older deploys.\n$")
+}
+
+func (s *S) TestAppDeployListPageAndSkip(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	command := appDeployList{}
+	err := command.Flags().Parse(true, []string{"-a", "test", "--page", "2", "--skip", "5"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, nil)
+	c.Assert(err, gocheck.ErrorMatches, "You can't use --page and --skip together.")
+}
+
+func (s *S) TestAppDeployListInvalidStatus(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	command := appDeployList{}
+	err := command.Flags().Parse(true, []string{"-a", "test", "--status", "ok"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, nil)
+	c.Assert(err, gocheck.ErrorMatches, `invalid status "ok", it must be success or failure`)
+}
+
+func (s *S) TestAppDeployListFilters(c *gocheck.C) {
+	deploys := []tsuruapp.DeployData{
+		{App: "test", Image: "v6", User: "admin@example.com", Origin: "git"},
+		{App: "test", Image: "v5", User: "admin@example.com", Origin: "app-deploy", Error: "failed"},
+		{App: "test", Image: "v4", User: "admin@example.com", Origin: "app-deploy"},
+		{App: "test", Image: "v3", User: "other@example.com", Origin: "app-deploy"},
+		{App: "test", Image: "v2", User: "admin@example.com", Origin: "app-deploy"},
+		{App: "test", Image: "v1", User: "admin@example.com", Origin: "app-deploy"},
+	}
+	trans := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: deploysJSON(c, deploys...), Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			query := req.URL.Query()
+			return query.Get("limit") == "100" && query.Get("skip") == "0"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	command := appDeployList{}
+	err := command.Flags().Parse(true, []string{
+		"-a", "test", "--user", "admin@example.com", "--origin", "upload", "--status", "success",
+		"--until", "2015-01-28T17:30:00Z", "--since", "2015-01-28T13:30:00Z",
+	})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	var images []string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 && strings.HasPrefix(fields[1], "v") {
+			images = append(images, fields[1])
+		}
+	}
+	c.Assert(images, gocheck.DeepEquals, []string{"v4", "v2"})
+}
+
+func (s *S) TestAppDeployListFiltersScanALimitedNumberOfDeploys(c *gocheck.C) {
+	old := maxDeployListBatches
+	maxDeployListBatches = 3
+	defer func() { maxDeployListBatches = old }()
+	batch := make([]tsuruapp.DeployData, deployListBatchSize)
+	for i := range batch {
+		batch[i] = tsuruapp.DeployData{App: "test", Image: "v1", User: "admin@example.com", Origin: "git"}
+	}
+	body := deploysJSON(c, batch...)
+	var requests int
+	trans := transportFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return &http.Response{Body: ioutil.NopCloser(strings.NewReader(body)), StatusCode: http.StatusOK}, nil
+	})
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	command := appDeployList{}
+	err := command.Flags().Parse(true, []string{"-a", "test", "--user", "nobody@example.com"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(requests, gocheck.Equals, 3)
+	c.Assert(stdout.String(), gocheck.Equals, "")
+	c.Assert(stderr.String(), gocheck.Equals, "Only the newest 300 deploys were searched, older ones weren't checked.\n")
+}
+
+func (s *S) TestAppDeployListAllApps(c *gocheck.C) {
+	deploys := []tsuruapp.DeployData{
+		{App: "app1", Image: "tsuru/app-app1:v2", Origin: "app-deploy"},
+		{App: "app2", Image: "tsuru/app-app2:v1", Origin: "app-deploy"},
+	}
+	trans := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: deploysJSON(c, deploys...), Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			_, ok := req.URL.Query()["app"]
+			return req.URL.Path == "/deploys" && !ok
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	command := appDeployList{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FailingFakeGuesser{ErrorMessage: "no app"}}}
+	err := command.Flags().Parse(true, []string{"--all-apps"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	lines := strings.Split(stdout.String(), "\n")
+	c.Assert(lines[1], gocheck.Matches, `\| App +\| Image \(Rollback\) +\|.*`)
+	c.Assert(lines[3], gocheck.Matches, `\| app1 +\| tsuru/app-app1:v2 +\|.*`)
+	c.Assert(lines[5], gocheck.Matches, `\| app2 +\| tsuru/app-app2:v1 +\|.*`)
+}
+
+func (s *S) TestAppDeployRollbackInfo(c *gocheck.C) {
+	desc := `Deploys an existing image for an app. You can list available images with ` + "`tsuru app-deploy-list`" + `.
+
+When no image is given, the deploys that can be rolled back to are listed, and
+you're asked to choose one of them. Use --previous to rollback to the last
+successful image before the current one, without being asked for it.`
 	expected := &cmd.Info{
 		Name:    "app-deploy-rollback",
-		Usage:   "app-deploy-rollback [-a/--app appname] [-y/--assume-yes] <image-name>",
-		Desc:    "Deploys an existing image for an app. You can list available images with `tsuru app-deploy-list`.",
-		MinArgs: 1,
+		Usage:   "app-deploy-rollback [-a/--app appname] [-y/--assume-yes] [--i-know-this-is-prod] [--previous] [image-name]",
+		Desc:    desc,
+		MaxArgs: 1,
 	}
 	c.Assert((&appDeployRollback{}).Info(), gocheck.DeepEquals, expected)
 }
@@ -288,3 +1164,706 @@ func (s *S) TestAppDeployRollback(c *gocheck.C) {
 	c.Assert(called, gocheck.Equals, true)
 	c.Assert(stdout.String(), gocheck.Equals, expectedOut)
 }
+
+func rollbackTransport(c *gocheck.C, deploys []tsuruapp.DeployData, image *string) *cmdtest.MultiConditionalTransport {
+	msg, err := json.Marshal(tsuruIo.SimpleJsonMessage{Message: "-- rolled back --"})
+	c.Assert(err, gocheck.IsNil)
+	return &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: deploysJSON(c, deploys...), Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return req.URL.Path == "/deploys" && req.URL.Query().Get("app") == "arrakis"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: string(msg), Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					*image = req.FormValue("image")
+					return req.URL.Path == "/apps/arrakis/deploy/rollback" && req.Method == "POST"
+				},
+			},
+		},
+	}
+}
+
+var rollbackDeploys = []tsuruapp.DeployData{
+	{App: "arrakis", Image: "tsuru/app-arrakis:v5", Error: "build failed"},
+	{App: "arrakis", Image: "tsuru/app-arrakis:v4", User: "paul@example.com", Origin: "app-deploy", CanRollback: true},
+	{App: "arrakis", Image: "tsuru/app-arrakis:v3", Error: "healthcheck failed", CanRollback: true},
+	{App: "arrakis", Image: "tsuru/app-arrakis:v2", User: "leto@example.com", Commit: "abc123", CanRollback: true},
+	{App: "arrakis", Image: "tsuru/app-arrakis:v1"},
+}
+
+func (s *S) TestAppDeployRollbackPicker(c *gocheck.C) {
+	var image string
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Stdin:  strings.NewReader("3\n"),
+	}
+	deploys := append([]tsuruapp.DeployData(nil), rollbackDeploys...)
+	trans := rollbackTransport(c, deploys, &image)
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := appDeployRollback{}
+	command.Flags().Parse(true, []string{"--app", "arrakis"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(image, gocheck.Equals, "tsuru/app-arrakis:v2")
+	out := stdout.String()
+	c.Assert(out, gocheck.Matches, `Deploys of app "arrakis" available for rollback:\n(?s).*`)
+	c.Assert(out, gocheck.Matches, `(?s).*\| 1 +\| tsuru/app-arrakis:v4 +\| app-deploy +\| paul@example.com +\|.*`)
+	c.Assert(out, gocheck.Matches, `(?s).*\| 2 +\| tsuru/app-arrakis:v3 +\|.*`)
+	c.Assert(out, gocheck.Matches, `(?s).*\| 3 +\| tsuru/app-arrakis:v2 +\| git +\| leto@example.com +\|.*`)
+	c.Assert(out, gocheck.Not(gocheck.Matches), `(?s).*tsuru/app-arrakis:v(1|5).*`)
+	c.Assert(out, gocheck.Matches, `(?s).*Choose the image to rollback to \(1-3\): -- rolled back --$`)
+}
+
+func (s *S) TestAppDeployRollbackPickerInvalidChoice(c *gocheck.C) {
+	var image string
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Stdin:  strings.NewReader("4\n"),
+	}
+	deploys := append([]tsuruapp.DeployData(nil), rollbackDeploys...)
+	client := cmd.NewClient(&http.Client{Transport: rollbackTransport(c, deploys, &image)}, nil, manager)
+	command := appDeployRollback{}
+	command.Flags().Parse(true, []string{"--app", "arrakis"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.ErrorMatches, `invalid choice "4", it must be a number between 1 and 3`)
+	c.Assert(image, gocheck.Equals, "")
+}
+
+func (s *S) TestAppDeployRollbackPickerAbort(c *gocheck.C) {
+	var image string
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Stdin:  strings.NewReader(""),
+	}
+	deploys := append([]tsuruapp.DeployData(nil), rollbackDeploys...)
+	client := cmd.NewClient(&http.Client{Transport: rollbackTransport(c, deploys, &image)}, nil, manager)
+	command := appDeployRollback{}
+	command.Flags().Parse(true, []string{"--app", "arrakis"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(image, gocheck.Equals, "")
+	c.Assert(stdout.String(), gocheck.Matches, "(?s).*Abort.\n$")
+}
+
+func (s *S) TestAppDeployRollbackPickerNoCandidates(c *gocheck.C) {
+	var image string
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	deploys := []tsuruapp.DeployData{{App: "arrakis", Image: "tsuru/app-arrakis:v1"}}
+	client := cmd.NewClient(&http.Client{Transport: rollbackTransport(c, deploys, &image)}, nil, manager)
+	command := appDeployRollback{}
+	command.Flags().Parse(true, []string{"--app", "arrakis"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.ErrorMatches, `app "arrakis" has no deploys to rollback to`)
+}
+
+func (s *S) TestAppDeployRollbackPrevious(c *gocheck.C) {
+	var image string
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	deploys := append([]tsuruapp.DeployData(nil), rollbackDeploys...)
+	client := cmd.NewClient(&http.Client{Transport: rollbackTransport(c, deploys, &image)}, nil, manager)
+	command := appDeployRollback{}
+	command.Flags().Parse(true, []string{"--app", "arrakis", "--previous", "-y"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(image, gocheck.Equals, "tsuru/app-arrakis:v2")
+	c.Assert(stdout.String(), gocheck.Equals, "-- rolled back --")
+}
+
+func (s *S) TestAppDeployRollbackPreviousNotFound(c *gocheck.C) {
+	var image string
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	deploys := []tsuruapp.DeployData{
+		{App: "arrakis", Image: "tsuru/app-arrakis:v2", CanRollback: true},
+		{App: "arrakis", Image: "tsuru/app-arrakis:v2", CanRollback: true},
+	}
+	client := cmd.NewClient(&http.Client{Transport: rollbackTransport(c, deploys, &image)}, nil, manager)
+	command := appDeployRollback{}
+	command.Flags().Parse(true, []string{"--app", "arrakis", "--previous", "-y"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.ErrorMatches, `no successful deploy before the current one found in the last 100 deploys of app "arrakis"`)
+	c.Assert(image, gocheck.Equals, "")
+}
+
+func (s *S) TestAppDeployRollbackPreviousWithImage(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr, Args: []string{"my-image"}}
+	command := appDeployRollback{}
+	command.Flags().Parse(true, []string{"--app", "arrakis", "--previous"})
+	err := command.Run(&context, nil)
+	c.Assert(err, gocheck.ErrorMatches, "You can't use --previous and give an image at the same time.")
+}
+
+func (s *S) TestAppDeployDiffInfo(c *gocheck.C) {
+	c.Assert((&appDeployDiff{}).Info().Name, gocheck.Equals, "app-deploy-diff")
+	c.Assert((&appDeployDiff{}).Info().MinArgs, gocheck.Equals, 2)
+	c.Assert((&appDeployDiff{}).Info().Desc, gocheck.Matches, "(?s).*Differences in environment variables aren't shown.*")
+}
+
+func (s *S) TestAppDeployDiff(c *gocheck.C) {
+	deploys := map[string]string{
+		"/deploys/54c922d0a46ec0e78501d84e": `{"ID": "54c922d0a46ec0e78501d84e", "App": "test", "Timestamp": "2015-01-28T17:56:32.583Z",
+			"Duration": 18781564759, "Commit": "1111111aaaa", "Image": "tsuru/app-test:v2", "User": "admin@example.com", "Origin": "git"}`,
+		"/deploys/54c92d91a46ec0e78501d86b": `{"ID": "54c92d91a46ec0e78501d86b", "App": "test", "Timestamp": "2015-01-28T18:42:25.725Z",
+			"Duration": 18709653486, "Commit": "2222222bbbb", "Image": "tsuru/app-test:v3", "User": "admin@example.com", "Origin": "git"}`,
+	}
+	var transports []cmdtest.ConditionalTransport
+	for _, path := range []string{"/deploys/54c92d91a46ec0e78501d86b", "/deploys/54c922d0a46ec0e78501d84e"} {
+		path := path
+		transports = append(transports, cmdtest.ConditionalTransport{
+			Transport: cmdtest.Transport{Message: deploys[path], Status: http.StatusOK},
+			CondFunc:  func(req *http.Request) bool { return req.Method == "GET" && req.URL.Path == path },
+		})
+	}
+	trans := cmdtest.MultiConditionalTransport{ConditionalTransports: transports}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Args:   []string{"54c92d91a46ec0e78501d86b", "54c922d0a46ec0e78501d84e"},
+	}
+	command := appDeployDiff{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	yellow := "\x1b[0;33;10m"
+	reset := "\x1b[0m"
+	out := stdout.String()
+	c.Assert(out, gocheck.Matches, `(?s).*\| App +\| test +\| test +\|.*`)
+	c.Assert(out, gocheck.Matches, `(?s).*\| Image +\| tsuru/app-test:v3 +\| `+regexp.QuoteMeta(yellow+"tsuru/app-test:v2"+reset)+` +\|.*`)
+	c.Assert(out, gocheck.Matches, `(?s).*\| User +\| admin@example.com +\| admin@example.com +\|.*`)
+	c.Assert(out, gocheck.Matches, `(?s).*\| Duration +\| 00:18 +\| 00:18 +\|.*`)
+	c.Assert(out, gocheck.Matches, "(?s).*\nCommit range: 1111111aaaa..2222222bbbb\nSee the commits with: git log 1111111aaaa..2222222bbbb\n$")
+}
+
+func (s *S) TestAppDeployDiffByImage(c *gocheck.C) {
+	deploys := []tsuruapp.DeployData{
+		{App: "test", Image: "tsuru/app-test:v3", Origin: "app-deploy"},
+		{App: "test", Image: "tsuru/app-test:v2", Origin: "rollback", Error: "timeout"},
+		{App: "test", Image: "tsuru/app-test:v1", Origin: "app-deploy"},
+	}
+	trans := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: deploysJSON(c, deploys...), Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/deploys" && req.URL.Query().Get("app") == "test"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Args:   []string{"v1", "tsuru/app-test:v2"},
+	}
+	command := appDeployDiff{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "test"}}}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	out := stdout.String()
+	c.Assert(out, gocheck.Matches, `(?s).*\| Image +\| tsuru/app-test:v1 +\| .*tsuru/app-test:v2.* +\|.*`)
+	c.Assert(out, gocheck.Matches, `(?s).*\| Error +\| +\| .*timeout.* +\|.*`)
+	c.Assert(out, gocheck.Not(gocheck.Matches), "(?s).*Commit range.*")
+}
+
+func (s *S) TestAppDeployDiffNotFound(c *gocheck.C) {
+	trans := cmdtest.Transport{Status: http.StatusNoContent}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Args:   []string{"v1", "v2"},
+	}
+	command := appDeployDiff{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "test"}}}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.ErrorMatches, `deploy "v1" not found in the last 100 deploys of app "test"`)
+}
+
+func (s *S) TestAppDeployInfoInfo(c *gocheck.C) {
+	c.Assert((&appDeployInfo{}).Info().Name, gocheck.Equals, "app-deploy-info")
+	c.Assert((&appDeployInfo{}).Info().MinArgs, gocheck.Equals, 1)
+}
+
+func (s *S) TestAppDeployInfo(c *gocheck.C) {
+	deploy := tsuruapp.DeployData{
+		ID:       bson.ObjectIdHex("54c922d0a46ec0e78501d84e"),
+		App:      "test",
+		Image:    "tsuru/app-test:v2",
+		Commit:   "1111111aaaa",
+		Origin:   "git",
+		User:     "admin@example.com",
+		Duration: 18781564759,
+		Error:    "exit status 1",
+		Log:      "---> Installing dependencies\ncollected 3 packages\nERROR: could not install foo\n",
+	}
+	data, err := json.Marshal(deploy)
+	c.Assert(err, gocheck.IsNil)
+	trans := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: string(data), Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.Method == "GET" && req.URL.Path == "/deploys/54c922d0a46ec0e78501d84e"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Args:   []string{"54c922d0a46ec0e78501d84e"},
+	}
+	command := appDeployInfo{}
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	red := "\x1b[0;31;10m"
+	reset := "\x1b[0m"
+	expected := `ID: 54c922d0a46ec0e78501d84e
+App: test
+Image: tsuru/app-test:v2
+Origin: git
+Commit: 1111111aaaa
+User: admin@example.com
+Date: ` + deploy.Timestamp.Local().Format(time.Stamp) + `
+Duration: 00:18
+Error: ` + red + "exit status 1" + reset + `
+
+Log:
+
+---> Installing dependencies
+collected 3 packages
+` + red + "ERROR: could not install foo" + reset + "\n"
+	c.Assert(stdout.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestAppDeployInfoJSONLog(c *gocheck.C) {
+	deploy := tsuruapp.DeployData{
+		App:   "test",
+		Image: "tsuru/app-test:v3",
+		Log:   `{"Message":"---> Building\n"}` + "\n" + `{"Message":"","Error":"healthcheck failed"}` + "\n" + `{"Message":"done"}`,
+	}
+	data, err := json.Marshal([]tsuruapp.DeployData{deploy})
+	c.Assert(err, gocheck.IsNil)
+	trans := cmdtest.Transport{Message: string(data), Status: http.StatusOK}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Args:   []string{"v3"},
+	}
+	command := appDeployInfo{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "test"}}}
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Matches, "(?s).*\nLog:\n\n---> Building\n\x1b\\[0;31;10mhealthcheck failed\x1b\\[0m\ndone\n$")
+}
+
+func (s *S) TestAppDeployInfoWithoutLog(c *gocheck.C) {
+	trans := cmdtest.Transport{Message: `{"App": "test", "Image": "tsuru/app-test:v1"}`, Status: http.StatusOK}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Args:   []string{"54c922d0a46ec0e78501d84e"},
+	}
+	command := appDeployInfo{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Matches, "(?s).*\nThe server didn't record the log of this deploy.\n$")
+}
+
+func (s *S) TestFailurePhase(c *gocheck.C) {
+	c.Assert(failurePhase("healthcheck fail(/status): wrong status code, expected 200, got: 500"), gocheck.Equals, deployPhaseHealthcheck)
+	c.Assert(failurePhase("Healthcheck timed out after 2m0s"), gocheck.Equals, deployPhaseHealthcheck)
+	c.Assert(failurePhase("exit status 1"), gocheck.Equals, deployPhaseBuild)
+	c.Assert(failurePhase("failed to start the units"), gocheck.Equals, deployPhaseBuild)
+	// The error of the server is all there is to tell the kind of a failure,
+	// so build errors mentioning healthchecks are taken as failed healthchecks.
+	c.Assert(failurePhase("ImportError: No module named healthcheck"), gocheck.Equals, deployPhaseHealthcheck)
+}
+
+func (s *S) TestFailedStep(c *gocheck.C) {
+	c.Assert(failedStep("ERROR: could not install foo"), gocheck.Equals, true)
+	c.Assert(failedStep(" ---> Failed to start the unit"), gocheck.Equals, true)
+	c.Assert(failedStep("command failed: exit status 2"), gocheck.Equals, true)
+	c.Assert(failedStep("---> Installing dependencies"), gocheck.Equals, false)
+	c.Assert(failedStep("no errors found"), gocheck.Equals, false)
+}
+
+// hooksProject creates a project with the given .tsuru.yaml and makes it the
+// current directory, returning a function that restores the previous one.
+func hooksProject(c *gocheck.C, config string) func() {
+	dir := c.MkDir()
+	err := ioutil.WriteFile(filepath.Join(dir, projectConfigFileName), []byte(config), 0644)
+	c.Assert(err, gocheck.IsNil)
+	err = ioutil.WriteFile(filepath.Join(dir, "Procfile"), []byte("web: ./app\n"), 0644)
+	c.Assert(err, gocheck.IsNil)
+	wd, err := os.Getwd()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(os.Chdir(dir), gocheck.IsNil)
+	return func() { os.Chdir(wd) }
+}
+
+const hooksConfig = `hooks:
+  pre-deploy:
+    - make test
+  post-deploy:
+    - ./notify.sh
+`
+
+func (s *S) TestDeployRunHooks(c *gocheck.C) {
+	defer hooksProject(c, hooksConfig)()
+	fexec := exectest.FakeExecutor{}
+	execut = &fexec
+	defer func() { execut = nil }()
+	var deployed bool
+	trans := cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{
+					Message: `[{"ID": "54c92d91a46ec0e78501d86b", "App": "secret", "Image": "tsuru/app-secret:v2"}]`,
+					Status:  http.StatusOK,
+				},
+				CondFunc: func(req *http.Request) bool {
+					return req.Method == "GET" && req.URL.Path == "/deploys" &&
+						req.URL.Query().Get("app") == "secret" && req.URL.Query().Get("limit") == "1"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: "deploy worked\nOK\n", Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					deployed = len(fexec.GetCommands("sh")) == 1
+					return req.Method == "POST" && req.URL.Path == "/apps/secret/deploy"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{
+					Message: `[{"ID": "54c92d91a46ec0e78501d86c", "App": "secret", "Image": "tsuru/app-secret:v3"},
+						{"ID": "54c92d91a46ec0e78501d86b", "App": "secret", "Image": "tsuru/app-secret:v2"}]`,
+					Status: http.StatusOK,
+				},
+				CondFunc: func(req *http.Request) bool {
+					return req.Method == "GET" && req.URL.Path == "/deploys" &&
+						req.URL.Query().Get("app") == "secret" && req.URL.Query().Get("limit") == "2"
+				},
+			},
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr, Args: []string{"Procfile"}}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(deployed, gocheck.Equals, true)
+	cmds := fexec.GetCommands("sh")
+	c.Assert(cmds, gocheck.HasLen, 2)
+	c.Assert(cmds[0].GetArgs(), gocheck.DeepEquals, []string{"-c", "make test"})
+	c.Assert(cmds[0].GetEnvs(), gocheck.DeepEquals, append(os.Environ(), "TSURU_APP_NAME=secret"))
+	c.Assert(cmds[1].GetArgs(), gocheck.DeepEquals, []string{"-c", "./notify.sh"})
+	expectedEnvs := append(os.Environ(),
+		"TSURU_APP_NAME=secret",
+		"TSURU_DEPLOY_RESULT=success",
+		"TSURU_DEPLOY_IMAGE=tsuru/app-secret:v3",
+		"TSURU_DEPLOY_ERROR=",
+	)
+	c.Assert(cmds[1].GetEnvs(), gocheck.DeepEquals, expectedEnvs)
+	c.Assert(stdout.String(), gocheck.Matches, "Running pre-deploy hook: make test\nArchive digest: .*\n(?s).*\nRunning post-deploy hook: ./notify.sh\n$")
+}
+
+// mkdirExecutor creates the directory dir when running a command, like a
+// hook that builds the files to deploy.
+type mkdirExecutor struct {
+	exectest.FakeExecutor
+	dir string
+}
+
+func (e *mkdirExecutor) Execute(opts exec.ExecuteOptions) error {
+	if err := os.MkdirAll(e.dir, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(e.dir, "index.html"), []byte("<h1>hi</h1>\n"), 0644); err != nil {
+		return err
+	}
+	return e.FakeExecutor.Execute(opts)
+}
+
+func (s *S) TestDeployRunPreDeployHookCreatesFiles(c *gocheck.C) {
+	defer hooksProject(c, "hooks:\n  pre-deploy:\n    - npm run build\n")()
+	wd, err := os.Getwd()
+	c.Assert(err, gocheck.IsNil)
+	fexec := mkdirExecutor{dir: filepath.Join(wd, "dist")}
+	execut = &fexec
+	defer func() { execut = nil }()
+	var uploaded bool
+	trans := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "deploy worked\nOK\n", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			uploaded = true
+			return req.Method == "POST" && req.URL.Path == "/apps/secret/deploy"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr, Args: []string{"dist"}}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}}
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(uploaded, gocheck.Equals, true)
+	c.Assert(fexec.GetCommands("sh"), gocheck.HasLen, 1)
+	c.Assert(stdout.String(), gocheck.Matches, "Running pre-deploy hook: npm run build\nArchive digest: sha256:.*\n(?s).*deploy worked\nOK\n$")
+}
+
+func (s *S) TestDeployRunHooksImageOfConcurrentDeploy(c *gocheck.C) {
+	defer hooksProject(c, hooksConfig)()
+	fexec := exectest.FakeExecutor{}
+	execut = &fexec
+	defer func() { execut = nil }()
+	trans := cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{
+					Message: `[{"ID": "54c92d91a46ec0e78501d86b", "App": "secret", "Image": "tsuru/app-secret:v2"}]`,
+					Status:  http.StatusOK,
+				},
+				CondFunc: func(req *http.Request) bool {
+					return req.Method == "GET" && req.URL.Path == "/deploys"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: "deploy worked\nOK\n", Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return req.Method == "POST" && req.URL.Path == "/apps/secret/deploy"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{
+					Message: `[{"ID": "54c92d91a46ec0e78501d86d", "App": "secret", "Image": "tsuru/app-secret:v4"},
+						{"ID": "54c92d91a46ec0e78501d86c", "App": "secret", "Image": "tsuru/app-secret:v3"}]`,
+					Status: http.StatusOK,
+				},
+				CondFunc: func(req *http.Request) bool {
+					return req.Method == "GET" && req.URL.Path == "/deploys"
+				},
+			},
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr, Args: []string{"Procfile"}}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	cmds := fexec.GetCommands("sh")
+	c.Assert(cmds, gocheck.HasLen, 2)
+	expectedEnvs := append(os.Environ(),
+		"TSURU_APP_NAME=secret",
+		"TSURU_DEPLOY_RESULT=success",
+		"TSURU_DEPLOY_IMAGE=",
+		"TSURU_DEPLOY_ERROR=",
+	)
+	c.Assert(cmds[1].GetEnvs(), gocheck.DeepEquals, expectedEnvs)
+}
+
+func (s *S) TestDeployRunHooksSkipIfUnchanged(c *gocheck.C) {
+	defer hooksProject(c, hooksConfig)()
+	fexec := exectest.FakeExecutor{}
+	execut = &fexec
+	defer func() { execut = nil }()
+	rfs := fstest.RecordingFs{}
+	fsystem = &rfs
+	defer func() {
+		fsystem = nil
+	}()
+	digest, _, err := archiveDigest(&archiveOptions{reproducible: true}, "Procfile")
+	c.Assert(err, gocheck.IsNil)
+	cache := deployCache{}
+	cache.set("http://localhost:8080", "secret", deployCacheEntry{Digest: digest, Deploy: "54c92d91a46ec0e78501d86b"})
+	c.Assert(cache.save(), gocheck.IsNil)
+	trans := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: `[{"ID": "54c92d91a46ec0e78501d86b", "App": "secret"}]`, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.Method == "GET" && req.URL.Path == "/deploys"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr, Args: []string{"Procfile"}}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}, skip: true}
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(exitr.(*recordingExiter).codes, gocheck.DeepEquals, []int{exitDeployUnchanged})
+	c.Assert(fexec.GetCommands("sh"), gocheck.HasLen, 0)
+}
+
+func (s *S) TestDeployRunHooksFromSubdirectory(c *gocheck.C) {
+	defer hooksProject(c, "hooks:\n  pre-deploy:\n    - make test\n")()
+	root, err := os.Getwd()
+	c.Assert(err, gocheck.IsNil)
+	dir := filepath.Join(root, "web")
+	c.Assert(os.Mkdir(dir, 0755), gocheck.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>hi</h1>\n"), 0644), gocheck.IsNil)
+	c.Assert(os.Chdir(dir), gocheck.IsNil)
+	fexec := exectest.FakeExecutor{}
+	execut = &fexec
+	defer func() { execut = nil }()
+	trans := cmdtest.Transport{Message: "deploy worked\nOK\n", Status: http.StatusOK}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr, Args: []string{"index.html"}}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}}
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	cmds := fexec.GetCommands("sh")
+	c.Assert(cmds, gocheck.HasLen, 1)
+	c.Assert(cmds[0].GetArgs(), gocheck.DeepEquals, []string{"-c", "make test"})
+	c.Assert(cmds[0].GetDir(), gocheck.Equals, root)
+}
+
+func (s *S) TestDeployRunPreDeployHookFailure(c *gocheck.C) {
+	defer hooksProject(c, hooksConfig)()
+	fexec := exectest.ErrorExecutor{}
+	execut = &fexec
+	defer func() { execut = nil }()
+	var called bool
+	trans := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "deploy worked\nOK\n", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			return true
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr, Args: []string{"Procfile"}}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.ErrorMatches, `pre-deploy hook "make test" failed: .*`)
+	c.Assert(called, gocheck.Equals, false)
+	c.Assert(fexec.GetCommands("sh"), gocheck.HasLen, 1)
+}
+
+func (s *S) TestDeployRunPostDeployHookAfterFailure(c *gocheck.C) {
+	defer hooksProject(c, hooksConfig)()
+	fexec := exectest.FakeExecutor{}
+	execut = &fexec
+	defer func() { execut = nil }()
+	result, err := json.Marshal(tsuruIo.SimpleJsonMessage{Error: "image not found"})
+	c.Assert(err, gocheck.IsNil)
+	trans := cmdtest.Transport{Message: string(result) + "\n", Status: http.StatusOK}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}, image: "myapp:v2"}
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.ErrorMatches, "build failed: image not found")
+	cmds := fexec.GetCommands("sh")
+	c.Assert(cmds, gocheck.HasLen, 2)
+	expectedEnvs := append(os.Environ(),
+		"TSURU_APP_NAME=secret",
+		"TSURU_DEPLOY_RESULT=failure",
+		"TSURU_DEPLOY_IMAGE=myapp:v2",
+		"TSURU_DEPLOY_ERROR=build failed: image not found",
+	)
+	c.Assert(cmds[1].GetEnvs(), gocheck.DeepEquals, expectedEnvs)
+}
+
+func (s *S) TestDeployRunNoHooks(c *gocheck.C) {
+	defer hooksProject(c, hooksConfig)()
+	fexec := exectest.FakeExecutor{}
+	execut = &fexec
+	defer func() { execut = nil }()
+	trans := cmdtest.Transport{Message: "deploy worked\nOK\n", Status: http.StatusOK}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	command := appDeploy{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "secret"}}}
+	err := command.Flags().Parse(true, []string{"--no-hooks", "Procfile"})
+	c.Assert(err, gocheck.IsNil)
+	context.Args = command.Flags().Args()
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(fexec.GetCommands("sh"), gocheck.HasLen, 0)
+}
+
+func (s *S) TestAppDeployListJSON(c *gocheck.C) {
+	deploys := []tsuruapp.DeployData{
+		{App: "test", Image: "tsuru/app-test:v2", Origin: "app-deploy", CanRollback: true},
+		{App: "test", Image: "tsuru/app-test:v1", Origin: "git", Commit: "abc123", Error: "build failed"},
+	}
+	trans := cmdtest.Transport{Message: deploysJSON(c, deploys...), Status: http.StatusOK}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	command := appDeployList{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "test"}}}
+	err := command.Flags().Parse(true, []string{"--json"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	var result []tsuruapp.DeployData
+	err = json.Unmarshal(stdout.Bytes(), &result)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.HasLen, 2)
+	c.Assert(result[0].Image, gocheck.Equals, "tsuru/app-test:v2")
+	c.Assert(result[0].CanRollback, gocheck.Equals, true)
+	c.Assert(result[1].Commit, gocheck.Equals, "abc123")
+	c.Assert(result[1].Error, gocheck.Equals, "build failed")
+	c.Assert(result[1].Timestamp.Equal(deploys[1].Timestamp), gocheck.Equals, true)
+}
+
+func (s *S) TestAppDeployListJSONWithoutDeploys(c *gocheck.C) {
+	trans := cmdtest.Transport{Status: http.StatusNoContent}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	command := appDeployList{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "test"}}}
+	err := command.Flags().Parse(true, []string{"--json"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "[]\n")
+}
+
+func (s *S) TestAppDeployListQuiet(c *gocheck.C) {
+	deploys := []tsuruapp.DeployData{
+		{ID: bson.ObjectIdHex("54c92d91a46ec0e78501d86b"), App: "test", Image: "tsuru/app-test:v2"},
+		{ID: bson.ObjectIdHex("54c922d0a46ec0e78501d84e"), App: "test", Image: "tsuru/app-test:v1"},
+	}
+	trans := cmdtest.Transport{Message: deploysJSON(c, deploys...), Status: http.StatusOK}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	command := appDeployList{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "test"}}}
+	err := command.Flags().Parse(true, []string{"--quiet"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "54c92d91a46ec0e78501d86b\n54c922d0a46ec0e78501d84e\n")
+}
+
+func (s *S) TestAppDeployListFormat(c *gocheck.C) {
+	deploys := []tsuruapp.DeployData{
+		{App: "test", Image: "tsuru/app-test:v2", User: "admin@example.com"},
+		{App: "test", Image: "tsuru/app-test:v1", User: "other@example.com"},
+	}
+	trans := cmdtest.Transport{Message: deploysJSON(c, deploys...), Status: http.StatusOK}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	command := appDeployList{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "test"}}}
+	err := command.Flags().Parse(true, []string{"--format", "{{.Image}} {{.User}}"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "tsuru/app-test:v2 admin@example.com\ntsuru/app-test:v1 other@example.com\n")
+}
@@ -0,0 +1,102 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	tsuruapp "github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/cmd"
+)
+
+// deployCache records, for each target and app, the digest of the last
+// archive deployed with --skip-if-unchanged and the deploy it resulted in.
+type deployCache map[string]map[string]deployCacheEntry
+
+type deployCacheEntry struct {
+	Digest string `json:"digest"`
+	Deploy string `json:"deploy"`
+}
+
+func deployCachePath() string {
+	return cmd.JoinWithUserDir(".tsuru", "deploys.json")
+}
+
+// loadDeployCache reads the deploy cache. A missing cache is empty.
+func loadDeployCache() (deployCache, error) {
+	cache := deployCache{}
+	f, err := filesystem().Open(deployCachePath())
+	if os.IsNotExist(err) {
+		return cache, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return cache, nil
+	}
+	err = json.Unmarshal(data, &cache)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deploy cache %s: %s", deployCachePath(), err)
+	}
+	return cache, nil
+}
+
+func (c deployCache) save() error {
+	err := filesystem().MkdirAll(cmd.JoinWithUserDir(".tsuru"), 0700)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := filesystem().OpenFile(deployCachePath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	return err
+}
+
+func (c deployCache) get(target, appName string) (deployCacheEntry, bool) {
+	entry, ok := c[target][appName]
+	return entry, ok
+}
+
+func (c deployCache) set(target, appName string, entry deployCacheEntry) {
+	if c[target] == nil {
+		c[target] = make(map[string]deployCacheEntry)
+	}
+	c[target][appName] = entry
+}
+
+// latestDeploy returns the last deploy of the given app, or nil when the app
+// was never deployed.
+func latestDeploy(client *cmd.Client, appName string) (*tsuruapp.DeployData, error) {
+	deploys, err := listDeploys(client, appName, 0, 1)
+	if err != nil || len(deploys) == 0 {
+		return nil, err
+	}
+	return &deploys[0], nil
+}
+
+// deployIdentity identifies a deploy in the server, falling back to its date
+// for servers that don't send deploy IDs.
+func deployIdentity(deploy *tsuruapp.DeployData) string {
+	if id := deploy.ID.Hex(); id != "" {
+		return id
+	}
+	return deploy.Timestamp.UTC().Format(time.RFC3339Nano)
+}
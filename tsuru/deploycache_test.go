@@ -0,0 +1,65 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"time"
+
+	tsuruapp "github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/cmd/cmdtest"
+	"github.com/tsuru/tsuru/fs/fstest"
+	"gopkg.in/mgo.v2/bson"
+	"launchpad.net/gocheck"
+)
+
+func (s *S) TestDeployCacheSaveAndLoad(c *gocheck.C) {
+	rfs := fstest.RecordingFs{}
+	fsystem = &rfs
+	defer func() {
+		fsystem = nil
+	}()
+	cache, err := loadDeployCache()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(cache, gocheck.HasLen, 0)
+	cache.set("http://tsuru.io", "myapp", deployCacheEntry{Digest: "sha256:abc", Deploy: "123"})
+	cache.set("http://tsuru.io", "other", deployCacheEntry{Digest: "sha256:def", Deploy: "456"})
+	err = cache.save()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(rfs.HasAction("mkdirall "+cmd.JoinWithUserDir(".tsuru")+" with mode 0700"), gocheck.Equals, true)
+	cache, err = loadDeployCache()
+	c.Assert(err, gocheck.IsNil)
+	entry, ok := cache.get("http://tsuru.io", "myapp")
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(entry, gocheck.DeepEquals, deployCacheEntry{Digest: "sha256:abc", Deploy: "123"})
+	_, ok = cache.get("http://other.tsuru.io", "myapp")
+	c.Assert(ok, gocheck.Equals, false)
+}
+
+func (s *S) TestLoadDeployCacheInvalid(c *gocheck.C) {
+	rfs := fstest.RecordingFs{FileContent: "not json"}
+	fsystem = &rfs
+	defer func() {
+		fsystem = nil
+	}()
+	_, err := loadDeployCache()
+	c.Assert(err, gocheck.ErrorMatches, "invalid deploy cache .*deploys.json: .*")
+}
+
+func (s *S) TestLatestDeployNoDeploys(c *gocheck.C) {
+	trans := cmdtest.Transport{Status: http.StatusNoContent}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	deploy, err := latestDeploy(client, "myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(deploy, gocheck.IsNil)
+}
+
+func (s *S) TestDeployIdentity(c *gocheck.C) {
+	deploy := tsuruapp.DeployData{Timestamp: time.Date(2015, 3, 4, 10, 20, 30, 0, time.UTC)}
+	c.Assert(deployIdentity(&deploy), gocheck.Equals, "2015-03-04T10:20:30Z")
+	deploy.ID = bson.ObjectIdHex("54c92d91a46ec0e78501d86b")
+	c.Assert(deployIdentity(&deploy), gocheck.Equals, "54c92d91a46ec0e78501d86b")
+}
@@ -16,8 +16,10 @@ import (
 	"sort"
 	"strings"
 
+	tsuruClient "github.com/tsuru/tsuru-client/tsuru/client"
 	"github.com/tsuru/tsuru/cmd"
 	tsuruIo "github.com/tsuru/tsuru/io"
+	"launchpad.net/gnuflag"
 )
 
 const envSetValidationMessage = `You must specify environment variables in the form "NAME=value".
@@ -28,12 +30,14 @@ Example:
 
 type envGet struct {
 	cmd.GuessingCommand
+	outputCommand
+	fs *gnuflag.FlagSet
 }
 
 func (c *envGet) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "env-get",
-		Usage: "env-get [-a/--app appname] [ENVIRONMENT_VARIABLE1] [ENVIRONMENT_VARIABLE2] ...",
+		Usage: "env-get [-a/--app appname] [--json | --yaml | --format <template> | -q/--quiet] [ENVIRONMENT_VARIABLE1] [ENVIRONMENT_VARIABLE2] ...",
 		Desc: `retrieve environment variables for an app.
 
 If you don't provide the app name, tsuru will try to guess it.`,
@@ -41,6 +45,16 @@ If you don't provide the app name, tsuru will try to guess it.`,
 	}
 }
 
+func (c *envGet) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = cmd.MergeFlagSet(
+			c.GuessingCommand.Flags(),
+			c.outputCommand.Flags(),
+		)
+	}
+	return c.fs
+}
+
 func (c *envGet) Run(context *cmd.Context, client *cmd.Client) error {
 	b, err := requestEnvURL("GET", c.GuessingCommand, context.Args, client)
 	if err != nil {
@@ -57,8 +71,19 @@ func (c *envGet) Run(context *cmd.Context, client *cmd.Client) error {
 		if v["public"].(bool) {
 			value = v["value"].(string)
 		}
+		v["value"] = value
 		formatted = append(formatted, fmt.Sprintf("%s=%s", v["name"], value))
 	}
+	if c.structured() {
+		if variables == nil {
+			variables = []map[string]interface{}{}
+		}
+		names := make([]string, len(variables))
+		for i, v := range variables {
+			names[i], _ = v["name"].(string)
+		}
+		return c.write(context.Stdout, variables, names)
+	}
 	sort.Strings(formatted)
 	fmt.Fprintln(context.Stdout, strings.Join(formatted, "\n"))
 	return nil
@@ -68,6 +93,8 @@ type envSet struct {
 	cmd.GuessingCommand
 }
 
+func (*envSet) mutatesTarget() {}
+
 func (c *envSet) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "env-set",
@@ -95,41 +122,30 @@ func (c *envSet) Run(context *cmd.Context, client *cmd.Client) error {
 		parts := strings.Split(v[1], "=")
 		variables[parts[0]] = strings.Join(parts[1:], "=")
 	}
-	var buf bytes.Buffer
-	json.NewEncoder(&buf).Encode(variables)
-	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/env", appName))
+	api, err := apiClient(client)
 	if err != nil {
 		return err
 	}
-	request, err := http.NewRequest("POST", url, &buf)
+	stream, err := api.SetEnv(appName, variables)
 	if err != nil {
 		return err
 	}
-	response, err := client.Do(request)
-	if err != nil {
-		return err
-	}
-	w := tsuruIo.NewStreamWriter(context.Stdout, nil)
-	for n := int64(1); n > 0 && err == nil; n, err = io.Copy(w, response.Body) {
-	}
-	if err != nil {
-		return err
-	}
-	unparsed := w.Remaining()
-	if len(unparsed) > 0 {
-		return fmt.Errorf("unparsed message error: %s", string(unparsed))
-	}
-	return nil
+	defer stream.Close()
+	return streamOutput(context.Stdout, stream, nil)
 }
 
 type envUnset struct {
 	cmd.GuessingCommand
+	protectedCheck
+	fs *gnuflag.FlagSet
 }
 
+func (*envUnset) mutatesTarget() {}
+
 func (c *envUnset) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "env-unset",
-		Usage: "env-unset <ENVIRONMENT_VARIABLE1> [ENVIRONMENT_VARIABLE2] ... [ENVIRONMENT_VARIABLEN] [-a/--app appname]",
+		Usage: "env-unset <ENVIRONMENT_VARIABLE1> [ENVIRONMENT_VARIABLE2] ... [ENVIRONMENT_VARIABLEN] [-a/--app appname] [--i-know-this-is-prod]",
 		Desc: `unset environment variables for an app.
 
 If you don't provide the app name, tsuru will try to guess it.`,
@@ -137,12 +153,25 @@ If you don't provide the app name, tsuru will try to guess it.`,
 	}
 }
 
+func (c *envUnset) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = cmd.MergeFlagSet(
+			c.GuessingCommand.Flags(),
+			c.protectedCheck.Flags(),
+		)
+	}
+	return c.fs
+}
+
 func (c *envUnset) Run(context *cmd.Context, client *cmd.Client) error {
 	appName, err := c.Guess()
 	if err != nil {
 		return err
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/env", appName))
+	if ok, err := c.confirmProtected(context, appName); !ok || err != nil {
+		return err
+	}
+	url, err := cmd.GetURL(tsuruClient.Path("/apps/%s/env", appName))
 	if err != nil {
 		return err
 	}
@@ -174,7 +203,7 @@ func requestEnvURL(method string, g cmd.GuessingCommand, args []string, client *
 	if err != nil {
 		return nil, err
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/env", appName))
+	url, err := cmd.GetURL(tsuruClient.Path("/apps/%s/env", appName))
 	if err != nil {
 		return nil, err
 	}
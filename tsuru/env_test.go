@@ -23,7 +23,7 @@ func (s *S) TestEnvGetInfo(c *gocheck.C) {
 
 If you don't provide the app name, tsuru will try to guess it.`
 	c.Assert(i.Name, gocheck.Equals, "env-get")
-	c.Assert(i.Usage, gocheck.Equals, "env-get [-a/--app appname] [ENVIRONMENT_VARIABLE1] [ENVIRONMENT_VARIABLE2] ...")
+	c.Assert(i.Usage, gocheck.Equals, "env-get [-a/--app appname] [--json | --yaml | --format <template> | -q/--quiet] [ENVIRONMENT_VARIABLE1] [ENVIRONMENT_VARIABLE2] ...")
 	c.Assert(i.Desc, gocheck.Equals, desc)
 	c.Assert(i.MinArgs, gocheck.Equals, 0)
 }
@@ -127,7 +127,7 @@ func (s *S) TestEnvGetWithoutTheFlag(c *gocheck.C) {
 	}
 	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
 	fake := &cmdtest.FakeGuesser{Name: "seek"}
-	err := (&envGet{cmd.GuessingCommand{G: fake}}).Run(&context, client)
+	err := (&envGet{GuessingCommand: cmd.GuessingCommand{G: fake}}).Run(&context, client)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(stdout.String(), gocheck.Equals, result)
 }
@@ -282,7 +282,7 @@ func (s *S) TestEnvUnsetInfo(c *gocheck.C) {
 
 If you don't provide the app name, tsuru will try to guess it.`
 	c.Assert(i.Name, gocheck.Equals, "env-unset")
-	c.Assert(i.Usage, gocheck.Equals, "env-unset <ENVIRONMENT_VARIABLE1> [ENVIRONMENT_VARIABLE2] ... [ENVIRONMENT_VARIABLEN] [-a/--app appname]")
+	c.Assert(i.Usage, gocheck.Equals, "env-unset <ENVIRONMENT_VARIABLE1> [ENVIRONMENT_VARIABLE2] ... [ENVIRONMENT_VARIABLEN] [-a/--app appname] [--i-know-this-is-prod]")
 	c.Assert(i.Desc, gocheck.Equals, desc)
 	c.Assert(i.MinArgs, gocheck.Equals, 1)
 }
@@ -334,7 +334,7 @@ func (s *S) TestEnvUnsetWithoutFlag(c *gocheck.C) {
 	}
 	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
 	fake := &cmdtest.FakeGuesser{Name: "otherapp"}
-	err = (&envUnset{cmd.GuessingCommand{G: fake}}).Run(&context, client)
+	err = (&envUnset{GuessingCommand: cmd.GuessingCommand{G: fake}}).Run(&context, client)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(stdout.String(), gocheck.Equals, expectedOut)
 }
@@ -348,3 +348,36 @@ func (s *S) TestRequestEnvURL(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(b, gocheck.DeepEquals, []byte(result))
 }
+
+func (s *S) TestEnvGetJSON(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	jsonResult := `[{"name": "DATABASE_USER", "value": "someuser", "public": true}, {"name": "DATABASE_PASSWORD", "value": "secret", "public": false}]`
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: &cmdtest.Transport{Message: jsonResult, Status: http.StatusOK}}, nil, manager)
+	command := envGet{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "someapp"}}}
+	err := command.Flags().Parse(true, []string{"--json"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	var variables []map[string]interface{}
+	err = json.Unmarshal(stdout.Bytes(), &variables)
+	c.Assert(err, gocheck.IsNil)
+	expected := []map[string]interface{}{
+		{"name": "DATABASE_USER", "value": "someuser", "public": true},
+		{"name": "DATABASE_PASSWORD", "value": "*** (private variable)", "public": false},
+	}
+	c.Assert(variables, gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestEnvGetQuiet(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	jsonResult := `[{"name": "DATABASE_USER", "value": "someuser", "public": true}, {"name": "DATABASE_PASSWORD", "value": "secret", "public": false}]`
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: &cmdtest.Transport{Message: jsonResult, Status: http.StatusOK}}, nil, manager)
+	command := envGet{GuessingCommand: cmd.GuessingCommand{G: &cmdtest.FakeGuesser{Name: "someapp"}}}
+	err := command.Flags().Parse(true, []string{"-q"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "DATABASE_USER\nDATABASE_PASSWORD\n")
+}
@@ -0,0 +1,29 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "os"
+
+// exiter terminates the program with the given status. Commands use it when
+// they need an exit status other than the one given by the command manager
+// for failures.
+type exiter interface {
+	Exit(int)
+}
+
+type osExiter struct{}
+
+func (osExiter) Exit(code int) {
+	os.Exit(code)
+}
+
+var exitr exiter
+
+func finisher() exiter {
+	if exitr == nil {
+		exitr = osExiter{}
+	}
+	return exitr
+}
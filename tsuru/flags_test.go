@@ -0,0 +1,54 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"time"
+
+	"launchpad.net/gocheck"
+)
+
+func (s *S) TestStringList(c *gocheck.C) {
+	var l stringList
+	c.Assert(l.Set("a"), gocheck.IsNil)
+	c.Assert(l.Set("b"), gocheck.IsNil)
+	c.Assert(l, gocheck.DeepEquals, stringList{"a", "b"})
+	c.Assert(l.String(), gocheck.Equals, "a,b")
+}
+
+func (s *S) TestParseTime(c *gocheck.C) {
+	now := time.Date(2015, 3, 10, 12, 0, 0, 0, time.Local)
+	var tests = []struct {
+		value    string
+		expected time.Time
+	}{
+		{"30m", time.Date(2015, 3, 10, 11, 30, 0, 0, time.Local)},
+		{"12h", time.Date(2015, 3, 10, 0, 0, 0, 0, time.Local)},
+		{"7d", time.Date(2015, 3, 3, 12, 0, 0, 0, time.Local)},
+		{"2015-01-28", time.Date(2015, 1, 28, 0, 0, 0, 0, time.Local)},
+		{"2015-01-28 15:04", time.Date(2015, 1, 28, 15, 4, 0, 0, time.Local)},
+		{"2015-01-28 15:04:05", time.Date(2015, 1, 28, 15, 4, 5, 0, time.Local)},
+		{"2015-01-28T15:04:05", time.Date(2015, 1, 28, 15, 4, 5, 0, time.Local)},
+		{"2015-01-28T15:04:05Z", time.Date(2015, 1, 28, 15, 4, 5, 0, time.UTC)},
+	}
+	for _, t := range tests {
+		parsed, err := parseTime(t.value, now)
+		c.Check(err, gocheck.IsNil)
+		c.Check(parsed.Equal(t.expected), gocheck.Equals, true, gocheck.Commentf("%s: got %s", t.value, parsed))
+	}
+}
+
+func (s *S) TestParseTimeInvalid(c *gocheck.C) {
+	_, err := parseTime("last week", time.Now())
+	c.Assert(err, gocheck.ErrorMatches, `invalid time "last week": .*`)
+}
+
+func (s *S) TestTimeFlag(c *gocheck.C) {
+	var f timeFlag
+	c.Assert(f.String(), gocheck.Equals, "")
+	c.Assert(f.Set("2015-01-28T15:04:05Z"), gocheck.IsNil)
+	c.Assert(f.String(), gocheck.Equals, "2015-01-28T15:04:05Z")
+	c.Assert(f.Set("yesterday"), gocheck.NotNil)
+}
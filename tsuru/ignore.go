@@ -0,0 +1,140 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const ignoreFileName = ".tsuruignore"
+
+// ignorePattern is a single line of a .tsuruignore file, following the
+// gitignore syntax.
+type ignorePattern struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+func parseIgnorePattern(line string) (ignorePattern, bool) {
+	var p ignorePattern
+	line = strings.TrimRight(line, "\r")
+	if strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-2] + " "
+	} else {
+		line = strings.TrimRight(line, " \t")
+	}
+	if line == "" || line[0] == '#' {
+		return p, false
+	}
+	if line[0] == '!' {
+		p.negate = true
+		line = line[1:]
+	} else if line[0] == '\\' && len(line) > 1 && (line[1] == '!' || line[1] == '#') {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return p, false
+	}
+	if strings.Contains(line, "/") {
+		p.anchored = true
+		line = strings.TrimLeft(line, "/")
+	}
+	p.segments = strings.Split(line, "/")
+	return p, true
+}
+
+func (p *ignorePattern) match(name string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	parts := strings.Split(name, "/")
+	if !p.anchored {
+		return matchSegments(p.segments, parts[len(parts)-1:])
+	}
+	return matchSegments(p.segments, parts)
+}
+
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+			if len(pattern) == 0 {
+				return len(parts) > 0
+			}
+			for i := range parts {
+				if matchSegments(pattern, parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// ignoreList is an ordered list of patterns that decides which files are left
+// out of deploy archives. As in gitignore, the last matching pattern wins, so
+// negated patterns may re-include files excluded by previous ones.
+type ignoreList []ignorePattern
+
+func (l *ignoreList) add(line string) {
+	if p, ok := parseIgnorePattern(line); ok {
+		*l = append(*l, p)
+	}
+}
+
+func (l *ignoreList) read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		l.add(scanner.Text())
+	}
+	return scanner.Err()
+}
+
+// readIgnoreFile loads the .tsuruignore file from the given directory. A
+// missing file is not an error.
+func readIgnoreFile(dir string) (ignoreList, error) {
+	var list ignoreList
+	f, err := os.Open(filepath.Join(dir, ignoreFileName))
+	if os.IsNotExist(err) {
+		return list, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	err = list.read(f)
+	return list, err
+}
+
+// ignored reports whether the given path, relative to the directory holding
+// the .tsuruignore file, should be left out of the archive.
+func (l ignoreList) ignored(name string, isDir bool) bool {
+	name = filepath.ToSlash(filepath.Clean(name))
+	var ignored bool
+	for i := range l {
+		if l[i].match(name, isDir) {
+			ignored = !l[i].negate
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,83 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"launchpad.net/gocheck"
+)
+
+func (s *S) TestIgnoreListIgnored(c *gocheck.C) {
+	var tests = []struct {
+		patterns []string
+		path     string
+		isDir    bool
+		ignored  bool
+	}{
+		{[]string{"*.log"}, "debug.log", false, true},
+		{[]string{"*.log"}, "logs/debug.log", false, true},
+		{[]string{"*.log"}, "debug.txt", false, false},
+		{[]string{"# *.log"}, "debug.log", false, false},
+		{[]string{"\\#notes"}, "#notes", false, true},
+		{[]string{".git"}, ".git", true, true},
+		{[]string{"build/"}, "build", true, true},
+		{[]string{"build/"}, "build", false, false},
+		{[]string{"build/"}, "src/build", true, true},
+		{[]string{"/build"}, "build", true, true},
+		{[]string{"/build"}, "src/build", true, false},
+		{[]string{"doc/*.txt"}, "doc/notes.txt", false, true},
+		{[]string{"doc/*.txt"}, "doc/server/arch.txt", false, false},
+		{[]string{"**/cache"}, "cache", true, true},
+		{[]string{"**/cache"}, "a/b/cache", true, true},
+		{[]string{"tmp/**"}, "tmp/a/b", false, true},
+		{[]string{"tmp/**"}, "tmp", true, false},
+		{[]string{"a/**/z"}, "a/z", false, true},
+		{[]string{"a/**/z"}, "a/b/c/z", false, true},
+		{[]string{"*.env", "!prod.env"}, "prod.env", false, false},
+		{[]string{"*.env", "!prod.env"}, "dev.env", false, true},
+		{[]string{"!prod.env", "*.env"}, "prod.env", false, true},
+		{[]string{"\\!important"}, "!important", false, true},
+		{[]string{"trailing   "}, "trailing", false, true},
+		{[]string{"space\\ "}, "space ", false, true},
+		{[]string{"*.log"}, "./debug.log", false, true},
+	}
+	for _, t := range tests {
+		var list ignoreList
+		for _, p := range t.patterns {
+			list.add(p)
+		}
+		c.Check(list.ignored(t.path, t.isDir), gocheck.Equals, t.ignored,
+			gocheck.Commentf("patterns %q, path %q", t.patterns, t.path))
+	}
+}
+
+func (s *S) TestIgnoreListRead(c *gocheck.C) {
+	content := "# dependencies\nnode_modules/\n\n*.pyc\n!keep.pyc\n"
+	var list ignoreList
+	err := list.read(strings.NewReader(content))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(list, gocheck.HasLen, 3)
+	c.Assert(list.ignored("node_modules", true), gocheck.Equals, true)
+	c.Assert(list.ignored("app.pyc", false), gocheck.Equals, true)
+	c.Assert(list.ignored("keep.pyc", false), gocheck.Equals, false)
+}
+
+func (s *S) TestReadIgnoreFile(c *gocheck.C) {
+	dir := c.MkDir()
+	err := ioutil.WriteFile(filepath.Join(dir, ".tsuruignore"), []byte(".env\n"), 0644)
+	c.Assert(err, gocheck.IsNil)
+	list, err := readIgnoreFile(dir)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(list.ignored(".env", false), gocheck.Equals, true)
+}
+
+func (s *S) TestReadIgnoreFileNotFound(c *gocheck.C) {
+	list, err := readIgnoreFile(c.MkDir())
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(list, gocheck.HasLen, 0)
+}
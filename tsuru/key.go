@@ -12,6 +12,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/tsuru/tsuru/cmd"
@@ -53,6 +54,8 @@ type keyAdd struct {
 	keyReader
 }
 
+func (*keyAdd) mutatesTarget() {}
+
 func (c *keyAdd) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "key-add",
@@ -90,6 +93,8 @@ func (c *keyAdd) Run(context *cmd.Context, client *cmd.Client) error {
 
 type keyRemove struct{}
 
+func (*keyRemove) mutatesTarget() {}
+
 func (c *keyRemove) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "key-remove",
@@ -118,6 +123,7 @@ func (c *keyRemove) Run(context *cmd.Context, client *cmd.Client) error {
 }
 
 type keyList struct {
+	outputCommand
 	notrunc bool
 	fs      *gnuflag.FlagSet
 }
@@ -125,7 +131,7 @@ type keyList struct {
 func (c *keyList) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "key-list",
-		Usage: "key-list [-n/--no-truncate]",
+		Usage: "key-list [-n/--no-truncate] [--json | --yaml | --format <template> | -q/--quiet]",
 		Desc:  "lists public keys registered in your account",
 	}
 }
@@ -149,6 +155,17 @@ func (c *keyList) Run(context *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return err
 	}
+	if c.structured() {
+		if keys == nil {
+			keys = map[string]string{}
+		}
+		names := make([]string, 0, len(keys))
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return c.write(context.Stdout, keys, names)
+	}
 	var table cmd.Table
 	table.Headers = cmd.Row{"Name", "Content"}
 	table.LineSeparator = c.notrunc
@@ -169,6 +186,7 @@ func (c *keyList) Flags() *gnuflag.FlagSet {
 		c.fs = gnuflag.NewFlagSet("key-list", gnuflag.ExitOnError)
 		c.fs.BoolVar(&c.notrunc, "n", false, "disable truncation of key content")
 		c.fs.BoolVar(&c.notrunc, "no-truncate", false, "disable truncation of key content")
+		c.fs = cmd.MergeFlagSet(c.fs, c.outputCommand.Flags())
 	}
 	return c.fs
 }
@@ -231,7 +231,7 @@ func (s *S) TestKeyListNoTruncate(c *gocheck.C) {
 func (s *S) TestInfoKeyList(c *gocheck.C) {
 	expected := &cmd.Info{
 		Name:  "key-list",
-		Usage: "key-list [-n/--no-truncate]",
+		Usage: "key-list [-n/--no-truncate] [--json | --yaml | --format <template> | -q/--quiet]",
 		Desc:  "lists public keys registered in your account",
 	}
 	c.Assert((&keyList{}).Info(), gocheck.DeepEquals, expected)
@@ -6,57 +6,249 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	tsuruClient "github.com/tsuru/tsuru-client/tsuru/client"
 	"github.com/tsuru/tsuru/cmd"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
 	tsuruIo "github.com/tsuru/tsuru/io"
 	"launchpad.net/gnuflag"
 )
 
 type appLog struct {
 	cmd.GuessingCommand
-	fs     *gnuflag.FlagSet
-	source string
-	unit   string
-	lines  int
-	follow bool
+	fs         *gnuflag.FlagSet
+	apps       stringList
+	team       string
+	source     string
+	unit       string
+	lines      int
+	follow     bool
+	since      timeFlag
+	until      timeFlag
+	grep       string
+	output     string
+	timestamps string
+	noColor    bool
+	noDate     bool
+	outputFile string
+	maxSize    int
+	quiet      bool
+	file       *rotatingFile
 }
 
 func (c *appLog) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "app-log",
-		Usage: "app-log [-a/--app appname] [-l/--lines numberOfLines] [-s/--source source] [-u/--unit unit] [-f/--follow]",
+		Usage: "app-log [-a/--app appname]... [--team teamname] [-l/--lines numberOfLines] [-s/--source source] [-u/--unit unit] [-f/--follow] [--since <date>] [--until <date>] [--grep <regexp>] [--output <text|json|logfmt>] [--timestamps <local|utc|rfc3339>] [--no-color] [--no-date] [--output-file <path> [--max-size <MB>] [-q/--quiet]]",
 		Desc: `show logs for an app.
 
-If you don't provide the app name, tsuru will try to guess it. The default number of lines is 10.`,
+If you don't provide the app name, tsuru will try to guess it. The default number of lines is 10.
+
+The logs of several apps can be shown at once, by using the -a/--app flag
+multiple times, or with --team, which shows the logs of all apps of the team.
+Each line is prefixed by the name of its app, and lines are printed in the
+order of their dates, also in --follow mode.
+
+The --since and --until flags show only the lines logged in the given period,
+and --grep only the lines whose message matches the given regular expression.
+They take dates, like 2015-01-28 15:04, or durations before now, like 30m or
+2h. These filters are applied to the lines returned by the server, so use
+--lines to look further back in time. They also apply in --follow mode.
+
+The --output flag prints each line as a JSON object (one per line), or in the
+logfmt format, instead of text. Dates are printed in the local time zone,
+unless --timestamps is utc or rfc3339, which is the default for json and
+logfmt. The --no-color and --no-date flags print plain text lines.
+
+In --follow mode, tsuru reconnects when the connection to the server drops,
+skipping the lines already printed, and tells when some lines may have been
+missed. It gives up when the stream can't be reopened for 10 minutes.
+
+The --output-file flag also writes the lines, without colors, to the given
+file. When the file reaches --max-size megabytes (100 by default, 0 disables
+it), it's renamed with the current time appended to its name and compressed
+with gzip, and a new file is started. Use -q/--quiet to only write the lines
+to the file.`,
 		MinArgs: 0,
 	}
 }
 
-type logFormatter struct{}
+const logDateLayout = "2006-01-02 15:04:05 -0700"
+
+// logFormatter prints log entries, dropping the ones that don't match its
+// filters. Zero values disable the filters, and print colored text with dates
+// in the local time zone.
+type logFormatter struct {
+	since      time.Time
+	until      time.Time
+	grep       *regexp.Regexp
+	output     string
+	timestamps string
+	noColor    bool
+	noDate     bool
+	cursor     *logCursor
+	// When app is set, lines are prefixed by its name, padded to appWidth,
+	// and sent to merger instead of being printed.
+	app      string
+	appWidth int
+	merger   *logMerger
+	// Lines are also written to file, if set, and not printed when quiet.
+	file  io.Writer
+	quiet bool
+}
 
-func (logFormatter) Format(out io.Writer, data []byte) error {
+func (f logFormatter) Format(out io.Writer, data []byte) error {
 	var logs []log
 	err := json.Unmarshal(data, &logs)
 	if err != nil {
 		return tsuruIo.ErrInvalidStreamChunk
 	}
 	for _, l := range logs {
-		date := l.Date.In(time.Local).Format("2006-01-02 15:04:05 -0700")
-		var prefix string
-		if l.Unit != "" {
-			prefix = fmt.Sprintf("%s [%s][%s]:", date, l.Source, l.Unit)
-		} else {
-			prefix = fmt.Sprintf("%s [%s]:", date, l.Source)
+		if f.cursor != nil && !f.cursor.next(l) {
+			continue
+		}
+		if !f.match(l) {
+			continue
+		}
+		if f.merger != nil {
+			f.merger.add(f, l)
+		} else if err := f.print(out, l); err != nil {
+			return err
 		}
-		fmt.Fprintf(out, "%s %s\n", cmd.Colorfy(prefix, "blue", "", ""), l.Message)
 	}
 	return nil
 }
 
+// print writes the entry to out and to the file of the formatter.
+func (f logFormatter) print(out io.Writer, l log) error {
+	if f.file != nil {
+		plain := f
+		plain.noColor = true
+		if err := plain.write(f.file, l); err != nil {
+			return err
+		}
+	}
+	if f.quiet {
+		return nil
+	}
+	return f.write(out, l)
+}
+
+func (f logFormatter) write(out io.Writer, l log) error {
+	switch f.output {
+	case "json":
+		return f.writeJSON(out, l)
+	case "logfmt":
+		return f.writeLogfmt(out, l)
+	}
+	return f.writeText(out, l)
+}
+
+func (f logFormatter) writeText(out io.Writer, l log) error {
+	prefix := "[" + l.Source + "]"
+	if l.Unit != "" {
+		prefix += "[" + l.Unit + "]"
+	}
+	prefix += ":"
+	if !f.noDate {
+		prefix = f.date(l) + " " + prefix
+	}
+	if !f.noColor {
+		prefix = cmd.Colorfy(prefix, "blue", "", "")
+	}
+	if f.app != "" {
+		name := fmt.Sprintf("%-*s", f.appWidth, f.app)
+		if !f.noColor {
+			name = cmd.Colorfy(name, appColor(f.app), "", "bold")
+		}
+		prefix = name + " " + prefix
+	}
+	_, err := fmt.Fprintf(out, "%s %s\n", prefix, l.Message)
+	return err
+}
+
+func (f logFormatter) writeJSON(out io.Writer, l log) error {
+	entry := struct {
+		App     string `json:"app,omitempty"`
+		Date    string `json:"date,omitempty"`
+		Source  string `json:"source"`
+		Unit    string `json:"unit,omitempty"`
+		Message string `json:"message"`
+	}{App: f.app, Source: l.Source, Unit: l.Unit, Message: l.Message}
+	if !f.noDate {
+		entry.Date = f.date(l)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", data)
+	return err
+}
+
+func (f logFormatter) writeLogfmt(out io.Writer, l log) error {
+	var fields []string
+	if f.app != "" {
+		fields = append(fields, "app="+logfmtValue(f.app))
+	}
+	if !f.noDate {
+		fields = append(fields, "date="+logfmtValue(f.date(l)))
+	}
+	fields = append(fields, "source="+logfmtValue(l.Source))
+	if l.Unit != "" {
+		fields = append(fields, "unit="+logfmtValue(l.Unit))
+	}
+	fields = append(fields, "message="+logfmtValue(l.Message))
+	_, err := fmt.Fprintln(out, strings.Join(fields, " "))
+	return err
+}
+
+// logfmtValue quotes values that are empty or contain spaces, quotes, equal
+// signs or control characters.
+func logfmtValue(value string) string {
+	if value == "" || strings.IndexFunc(value, needsLogfmtQuote) >= 0 {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+func needsLogfmtQuote(r rune) bool {
+	return r <= ' ' || r == '"' || r == '=' || r == utf8.RuneError || unicode.IsControl(r)
+}
+
+func (f logFormatter) date(l log) string {
+	switch f.timestamps {
+	case "utc":
+		return l.Date.In(time.UTC).Format(logDateLayout)
+	case "rfc3339":
+		return l.Date.In(time.Local).Format(time.RFC3339Nano)
+	}
+	return l.Date.In(time.Local).Format(logDateLayout)
+}
+
+func (f logFormatter) match(l log) bool {
+	switch {
+	case !f.since.IsZero() && l.Date.Before(f.since):
+		return false
+	case !f.until.IsZero() && !l.Date.Before(f.until):
+		return false
+	case f.grep != nil && !f.grep.MatchString(l.Message):
+		return false
+	}
+	return true
+}
+
 type log struct {
 	Date    time.Time
 	Message string
@@ -65,48 +257,325 @@ type log struct {
 }
 
 func (c *appLog) Run(context *cmd.Context, client *cmd.Client) error {
-	appName, err := c.Guess()
+	if c.output != "" && c.output != "text" && c.output != "json" && c.output != "logfmt" {
+		return fmt.Errorf("invalid output %q, it must be text, json or logfmt", c.output)
+	}
+	if c.timestamps != "" && c.timestamps != "local" && c.timestamps != "utc" && c.timestamps != "rfc3339" {
+		return fmt.Errorf("invalid timestamps %q, it must be local, utc or rfc3339", c.timestamps)
+	}
+	formatter := logFormatter{
+		since:      c.since.Time,
+		until:      c.until.Time,
+		output:     c.output,
+		timestamps: c.timestamps,
+		noColor:    c.noColor,
+		noDate:     c.noDate,
+	}
+	if formatter.timestamps == "" && (c.output == "json" || c.output == "logfmt") {
+		formatter.timestamps = "rfc3339"
+	}
+	var err error
+	if c.outputFile != "" {
+		c.file, err = openRotatingFile(c.outputFile, int64(c.maxSize)*1024*1024)
+		if err != nil {
+			return err
+		}
+		defer c.file.Close()
+		formatter.file = c.file
+		formatter.quiet = c.quiet
+	} else if c.quiet {
+		return errors.New("the --quiet flag requires --output-file")
+	}
+	if c.grep != "" {
+		formatter.grep, err = regexp.Compile(c.grep)
+		if err != nil {
+			return fmt.Errorf("invalid --grep expression: %s", err)
+		}
+	}
+	appNames, err := c.appNames(client)
 	if err != nil {
 		return err
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/log?lines=%d", appName, c.lines))
-	if err != nil {
+	if len(appNames) > 1 {
+		return c.tailApps(context, client, appNames, formatter)
+	}
+	return c.tail(context, client, appNames[0], formatter)
+}
+
+// appNames returns the apps given with -a/--app and the apps of the team given
+// with --team, or the guessed app when there are none.
+func (c *appLog) appNames(client *cmd.Client) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, name := range c.apps {
+		add(name)
+	}
+	if c.team != "" {
+		api, err := apiClient(client)
+		if err != nil {
+			return nil, err
+		}
+		apps, err := api.ListApps()
+		if err != nil {
+			return nil, err
+		}
+		var found bool
+		for _, a := range apps {
+			if a.TeamOwner == c.team || in(c.team, a.Teams) {
+				add(a.Name)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("team %q has no apps", c.team)
+		}
+	}
+	if len(names) == 0 {
+		name, err := c.Guess()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// tail prints the logs of an app and, in --follow mode, keeps printing them
+// until the stream can't be reopened.
+func (c *appLog) tail(context *cmd.Context, client *cmd.Client, appName string, formatter logFormatter) error {
+	response, err := c.request(client, appName, c.lines)
+	if err != nil || response == nil {
 		return err
 	}
+	if !c.follow {
+		c.read(context, response, formatter)
+		return c.fileErr()
+	}
+	stream := "log stream"
+	if formatter.app != "" {
+		stream = fmt.Sprintf("log stream of app %q", formatter.app)
+	}
+	formatter.cursor = newLogCursor(c.resumeLines(), func() {
+		c.notice(context, "The "+stream+" was interrupted, some lines may be missing.")
+	})
+	for {
+		c.read(context, response, formatter)
+		if err := c.fileErr(); err != nil {
+			return err
+		}
+		c.notice(context, "The "+stream+" was closed, reconnecting...")
+		response, err = c.reconnect(context, client, appName, stream)
+		if err != nil {
+			return err
+		}
+		formatter.cursor.resume()
+	}
+}
+
+// notice prints a message about the log stream, dimmed to set it apart from
+// the log lines.
+func (c *appLog) notice(context *cmd.Context, msg string) {
+	if !c.noColor {
+		msg = cmd.Colorfy(msg, "white", "", "dim")
+	}
+	fmt.Fprintln(context.Stderr, msg)
+}
+
+// request asks for the logs of the app, returning a nil response when there
+// are no logs.
+func (c *appLog) request(client *cmd.Client, appName string, lines int) (*http.Response, error) {
+	query := url.Values{"lines": {strconv.Itoa(lines)}}
 	if c.source != "" {
-		url = fmt.Sprintf("%s&source=%s", url, c.source)
+		query.Set("source", c.source)
 	}
 	if c.unit != "" {
-		url = fmt.Sprintf("%s&unit=%s", url, c.unit)
+		query.Set("unit", c.unit)
 	}
 	if c.follow {
-		url += "&follow=1"
+		query.Set("follow", "1")
+	}
+	url, err := cmd.GetURL(tsuruClient.Path("/apps/%s/log", appName) + "?" + query.Encode())
+	if err != nil {
+		return nil, err
 	}
 	request, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	response, err := client.Do(request)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if response.StatusCode == http.StatusNoContent {
-		return nil
+		response.Body.Close()
+		return nil, nil
 	}
+	return response, nil
+}
+
+// read prints the logs in the response until the server closes it. Data left
+// unparsed is printed as an error, unless following the logs, where it's what
+// was being sent when the connection dropped.
+func (c *appLog) read(context *cmd.Context, response *http.Response, formatter logFormatter) {
 	defer response.Body.Close()
-	w := tsuruIo.NewStreamWriter(context.Stdout, logFormatter{})
+	w := tsuruIo.NewStreamWriter(context.Stdout, formatter)
+	var err error
 	for n := int64(1); n > 0 && err == nil; n, err = io.Copy(w, response.Body) {
 	}
 	unparsed := w.Remaining()
-	if len(unparsed) > 0 {
+	if len(unparsed) > 0 && !c.follow {
 		fmt.Fprintf(context.Stdout, "Error: %s", string(unparsed))
 	}
-	return nil
+}
+
+// fileErr returns the error that stopped the writes to --output-file.
+func (c *appLog) fileErr() error {
+	if c.file == nil {
+		return nil
+	}
+	return c.file.Err()
+}
+
+// Delays between attempts to reconnect to the log stream, doubled after each
+// failure, and how long the attempts go on before giving up.
+var (
+	logReconnectDelay    = time.Second
+	maxLogReconnectDelay = 30 * time.Second
+	logReconnectTimeout  = 10 * time.Minute
+)
+
+// logResumeLines is the minimum number of lines asked for when reconnecting
+// to the log stream, so the new stream likely overlaps the interrupted one.
+const logResumeLines = 100
+
+// resumeLines is the number of lines asked for when reconnecting to the log
+// stream, and remembered to skip the ones sent again.
+func (c *appLog) resumeLines() int {
+	if c.lines < logResumeLines {
+		return logResumeLines
+	}
+	return c.lines
+}
+
+// reconnect opens the log stream again after it was closed, retrying with
+// exponential backoff while the server is unreachable or failing, for up to
+// logReconnectTimeout. Other errors, like a removed app, end the command.
+func (c *appLog) reconnect(context *cmd.Context, client *cmd.Client, appName, stream string) (*http.Response, error) {
+	lines := c.resumeLines()
+	delay := logReconnectDelay
+	deadline := time.Now().Add(logReconnectTimeout)
+	for {
+		time.Sleep(delay)
+		response, err := c.request(client, appName, lines)
+		if err == nil && response != nil {
+			return response, nil
+		}
+		if e, ok := err.(*tsuruErrors.HTTP); ok && e.Code < http.StatusInternalServerError {
+			return nil, err
+		}
+		if err == nil {
+			err = errors.New("the server sent no logs")
+		}
+		delay *= 2
+		if delay > maxLogReconnectDelay {
+			delay = maxLogReconnectDelay
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return nil, fmt.Errorf("the %s couldn't be reopened in %s: %s", stream, logReconnectTimeout, err)
+		}
+		c.notice(context, fmt.Sprintf("Failed to reconnect: %s. Retrying in %s...", err, delay))
+	}
+}
+
+// logCursor remembers the last entries received while following the logs,
+// so the entries sent again after a reconnection aren't printed twice.
+// Entries of different units may arrive slightly out of order, so entries are
+// only skipped in the overlap window opened by resume, which ends when an
+// entry newer than all entries received before arrives.
+type logCursor struct {
+	last  time.Time
+	seen  map[string]int
+	keys  []logCursorKey
+	limit int
+	// from is the date of the oldest entry remembered when resuming: older
+	// entries sent again can't be told apart from the ones received before.
+	from time.Time
+	// overlap tells whether an entry received before was sent again after
+	// resuming. New entries without an overlap mean a gap, reported to gap.
+	resumed bool
+	overlap bool
+	gap     func()
+}
+
+type logCursorKey struct {
+	date time.Time
+	key  string
+}
+
+func newLogCursor(limit int, gap func()) *logCursor {
+	return &logCursor{seen: make(map[string]int), limit: limit, gap: gap}
+}
+
+// resume opens the overlap window, after a reconnection.
+func (c *logCursor) resume() {
+	c.resumed = true
+	c.overlap = false
+	c.from = time.Time{}
+	for _, k := range c.keys {
+		if c.from.IsZero() || k.date.Before(c.from) {
+			c.from = k.date
+		}
+	}
+}
+
+// next reports whether the entry should be printed.
+func (c *logCursor) next(l log) bool {
+	key := l.Date.Format(time.RFC3339Nano) + "\x00" + l.Source + "\x00" + l.Unit + "\x00" + l.Message
+	if c.resumed {
+		if !l.Date.After(c.last) {
+			c.overlap = true
+			if l.Date.Before(c.from) || c.seen[key] > 0 {
+				return false
+			}
+			c.remember(l.Date, key)
+			return true
+		}
+		c.resumed = false
+		if !c.overlap && c.gap != nil {
+			c.gap()
+		}
+	}
+	c.remember(l.Date, key)
+	return true
+}
+
+func (c *logCursor) remember(date time.Time, key string) {
+	if date.After(c.last) {
+		c.last = date
+	}
+	c.seen[key]++
+	c.keys = append(c.keys, logCursorKey{date: date, key: key})
+	if len(c.keys) > c.limit {
+		old := c.keys[0].key
+		if c.seen[old]--; c.seen[old] == 0 {
+			delete(c.seen, old)
+		}
+		c.keys = c.keys[1:]
+	}
 }
 
 func (c *appLog) Flags() *gnuflag.FlagSet {
 	if c.fs == nil {
-		c.fs = c.GuessingCommand.Flags()
+		c.fs = gnuflag.NewFlagSet("", gnuflag.ContinueOnError)
+		c.fs.Var(&c.apps, "app", "The name of the app. May be given multiple times")
+		c.fs.Var(&c.apps, "a", "The name of the app. May be given multiple times")
+		c.fs.StringVar(&c.team, "team", "", "Show the logs of all apps of the given team")
 		c.fs.IntVar(&c.lines, "lines", 10, "The number of log lines to display")
 		c.fs.IntVar(&c.lines, "l", 10, "The number of log lines to display")
 		c.fs.StringVar(&c.source, "source", "", "The log from the given source")
@@ -115,6 +584,17 @@ func (c *appLog) Flags() *gnuflag.FlagSet {
 		c.fs.StringVar(&c.unit, "u", "", "The log from the given unit")
 		c.fs.BoolVar(&c.follow, "follow", false, "Follow logs")
 		c.fs.BoolVar(&c.follow, "f", false, "Follow logs")
+		c.fs.Var(&c.since, "since", "Show only the lines logged after the given date")
+		c.fs.Var(&c.until, "until", "Show only the lines logged before the given date")
+		c.fs.StringVar(&c.grep, "grep", "", "Show only the lines whose message matches the given regular expression")
+		c.fs.StringVar(&c.output, "output", "text", "The format of the lines: text, json or logfmt")
+		c.fs.StringVar(&c.timestamps, "timestamps", "", "How to print dates: local, utc or rfc3339")
+		c.fs.BoolVar(&c.noColor, "no-color", false, "Don't color the lines")
+		c.fs.BoolVar(&c.noDate, "no-date", false, "Don't print the dates of the lines")
+		c.fs.StringVar(&c.outputFile, "output-file", "", "Also write the lines to the given file")
+		c.fs.IntVar(&c.maxSize, "max-size", 100, "Size in megabytes of the output file before it's rotated")
+		c.fs.BoolVar(&c.quiet, "quiet", false, "Only write the lines to the output file")
+		c.fs.BoolVar(&c.quiet, "q", false, "Only write the lines to the output file")
 	}
 	return c.fs
 }
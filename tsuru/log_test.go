@@ -7,7 +7,10 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/tsuru/tsuru/cmd"
@@ -39,6 +42,169 @@ func (s *S) TestFormatterUsesCurrentTimeZone(c *gocheck.C) {
 	c.Assert(writer.String(), gocheck.Equals, expected)
 }
 
+func (s *S) TestFormatterFilters(c *gocheck.C) {
+	t := time.Date(2015, 3, 10, 14, 0, 0, 0, time.UTC)
+	logs := []log{
+		{Date: t.Add(-time.Hour), Message: "GET /healthcheck 200", Source: "app"},
+		{Date: t, Message: "GET /users 500", Source: "app"},
+		{Date: t.Add(time.Hour), Message: "GET /users 502", Source: "app"},
+		{Date: t.Add(2 * time.Hour), Message: "GET /users 500", Source: "app"},
+	}
+	data, err := json.Marshal(logs)
+	c.Assert(err, gocheck.IsNil)
+	var writer bytes.Buffer
+	old := time.Local
+	time.Local = time.UTC
+	defer func() {
+		time.Local = old
+	}()
+	formatter := logFormatter{
+		since: t,
+		until: t.Add(2 * time.Hour),
+		grep:  regexp.MustCompile(" 50[0-9]$"),
+	}
+	err = formatter.Format(&writer, data)
+	c.Assert(err, gocheck.IsNil)
+	tfmt := "2006-01-02 15:04:05 -0700"
+	expected := cmd.Colorfy(t.Format(tfmt)+" [app]:", "blue", "", "") + " GET /users 500\n"
+	expected += cmd.Colorfy(t.Add(time.Hour).Format(tfmt)+" [app]:", "blue", "", "") + " GET /users 502\n"
+	c.Assert(writer.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestFormatterOutputs(c *gocheck.C) {
+	t := time.Date(2015, 3, 10, 14, 0, 0, 0, time.UTC)
+	logs := []log{
+		{Date: t, Message: `user="gopher" logged in`, Source: "app", Unit: "abcdef"},
+		{Date: t.Add(time.Second), Message: "restarting", Source: "tsuru"},
+	}
+	data, err := json.Marshal(logs)
+	c.Assert(err, gocheck.IsNil)
+	var tests = []struct {
+		formatter logFormatter
+		expected  string
+	}{
+		{
+			logFormatter{timestamps: "utc", noColor: true},
+			"2015-03-10 14:00:00 +0000 [app][abcdef]: user=\"gopher\" logged in\n" +
+				"2015-03-10 14:00:01 +0000 [tsuru]: restarting\n",
+		},
+		{
+			logFormatter{noDate: true, noColor: true},
+			"[app][abcdef]: user=\"gopher\" logged in\n[tsuru]: restarting\n",
+		},
+		{
+			logFormatter{output: "json", timestamps: "rfc3339"},
+			`{"date":"2015-03-10T14:00:00Z","source":"app","unit":"abcdef","message":"user=\"gopher\" logged in"}` + "\n" +
+				`{"date":"2015-03-10T14:00:01Z","source":"tsuru","message":"restarting"}` + "\n",
+		},
+		{
+			logFormatter{output: "json", noDate: true},
+			`{"source":"app","unit":"abcdef","message":"user=\"gopher\" logged in"}` + "\n" +
+				`{"source":"tsuru","message":"restarting"}` + "\n",
+		},
+		{
+			logFormatter{output: "logfmt", timestamps: "rfc3339"},
+			`date=2015-03-10T14:00:00Z source=app unit=abcdef message="user=\"gopher\" logged in"` + "\n" +
+				`date=2015-03-10T14:00:01Z source=tsuru message=restarting` + "\n",
+		},
+	}
+	old := time.Local
+	time.Local = time.UTC
+	defer func() {
+		time.Local = old
+	}()
+	for _, test := range tests {
+		var writer bytes.Buffer
+		err = test.formatter.Format(&writer, data)
+		c.Check(err, gocheck.IsNil)
+		c.Check(writer.String(), gocheck.Equals, test.expected)
+	}
+}
+
+func (s *S) TestLogfmtValue(c *gocheck.C) {
+	c.Assert(logfmtValue("started"), gocheck.Equals, "started")
+	c.Assert(logfmtValue(""), gocheck.Equals, `""`)
+	c.Assert(logfmtValue("a b"), gocheck.Equals, `"a b"`)
+	c.Assert(logfmtValue("a=b"), gocheck.Equals, `"a=b"`)
+	c.Assert(logfmtValue("line\nbreak"), gocheck.Equals, `"line\nbreak"`)
+}
+
+func (s *S) TestAppLogJSONOutput(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	t := time.Date(2015, 3, 10, 14, 0, 0, 0, time.UTC)
+	logs := []log{{Date: t, Message: "creating app lost", Source: "tsuru"}}
+	result, err := json.Marshal(logs)
+	c.Assert(err, gocheck.IsNil)
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	command := appLog{}
+	transport := cmdtest.Transport{
+		Message: string(result),
+		Status:  http.StatusOK,
+	}
+	client := cmd.NewClient(&http.Client{Transport: &transport}, nil, manager)
+	command.Flags().Parse(true, []string{"--app", "appName", "--output", "json"})
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	var entry map[string]string
+	err = json.Unmarshal(stdout.Bytes(), &entry)
+	c.Assert(err, gocheck.IsNil)
+	date, err := time.Parse(time.RFC3339Nano, entry["date"])
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(date.Equal(t), gocheck.Equals, true)
+	c.Assert(entry["message"], gocheck.Equals, "creating app lost")
+}
+
+func (s *S) TestAppLogInvalidOutput(c *gocheck.C) {
+	context := cmd.Context{}
+	command := appLog{}
+	command.Flags().Parse(true, []string{"--app", "appName", "--output", "xml"})
+	err := command.Run(&context, nil)
+	c.Assert(err, gocheck.ErrorMatches, `invalid output "xml", it must be text, json or logfmt`)
+	command = appLog{}
+	command.Flags().Parse(true, []string{"--app", "appName", "--timestamps", "gmt"})
+	err = command.Run(&context, nil)
+	c.Assert(err, gocheck.ErrorMatches, `invalid timestamps "gmt", it must be local, utc or rfc3339`)
+}
+
+func (s *S) TestAppLogWithFilters(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	t := time.Now()
+	logs := []log{
+		{Date: t.Add(-3 * time.Hour), Message: "worker started", Source: "app"},
+		{Date: t.Add(-30 * time.Minute), Message: "worker started", Source: "app"},
+		{Date: t.Add(-20 * time.Minute), Message: "request finished", Source: "app"},
+	}
+	result, err := json.Marshal(logs)
+	c.Assert(err, gocheck.IsNil)
+	tfmt := "2006-01-02 15:04:05 -0700"
+	expected := cmd.Colorfy(t.Add(-30*time.Minute).In(time.Local).Format(tfmt)+" [app]:", "blue", "", "") + " worker started\n"
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	command := appLog{}
+	transport := cmdtest.Transport{
+		Message: string(result),
+		Status:  http.StatusOK,
+	}
+	client := cmd.NewClient(&http.Client{Transport: &transport}, nil, manager)
+	command.Flags().Parse(true, []string{"--app", "appName", "--since", "1h", "--grep", "^worker"})
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestAppLogInvalidGrep(c *gocheck.C) {
+	context := cmd.Context{}
+	command := appLog{}
+	command.Flags().Parse(true, []string{"--app", "appName", "--grep", "worker("})
+	err := command.Run(&context, nil)
+	c.Assert(err, gocheck.ErrorMatches, "invalid --grep expression: .*")
+}
+
 func (s *S) TestAppLog(c *gocheck.C) {
 	var stdout, stderr bytes.Buffer
 	t := time.Now()
@@ -147,10 +313,36 @@ func (s *S) TestAppLogShouldReturnNilIfHasNoContent(c *gocheck.C) {
 func (s *S) TestAppLogInfo(c *gocheck.C) {
 	expected := &cmd.Info{
 		Name:  "app-log",
-		Usage: "app-log [-a/--app appname] [-l/--lines numberOfLines] [-s/--source source] [-u/--unit unit] [-f/--follow]",
+		Usage: "app-log [-a/--app appname]... [--team teamname] [-l/--lines numberOfLines] [-s/--source source] [-u/--unit unit] [-f/--follow] [--since <date>] [--until <date>] [--grep <regexp>] [--output <text|json|logfmt>] [--timestamps <local|utc|rfc3339>] [--no-color] [--no-date] [--output-file <path> [--max-size <MB>] [-q/--quiet]]",
 		Desc: `show logs for an app.
 
-If you don't provide the app name, tsuru will try to guess it. The default number of lines is 10.`,
+If you don't provide the app name, tsuru will try to guess it. The default number of lines is 10.
+
+The logs of several apps can be shown at once, by using the -a/--app flag
+multiple times, or with --team, which shows the logs of all apps of the team.
+Each line is prefixed by the name of its app, and lines are printed in the
+order of their dates, also in --follow mode.
+
+The --since and --until flags show only the lines logged in the given period,
+and --grep only the lines whose message matches the given regular expression.
+They take dates, like 2015-01-28 15:04, or durations before now, like 30m or
+2h. These filters are applied to the lines returned by the server, so use
+--lines to look further back in time. They also apply in --follow mode.
+
+The --output flag prints each line as a JSON object (one per line), or in the
+logfmt format, instead of text. Dates are printed in the local time zone,
+unless --timestamps is utc or rfc3339, which is the default for json and
+logfmt. The --no-color and --no-date flags print plain text lines.
+
+In --follow mode, tsuru reconnects when the connection to the server drops,
+skipping the lines already printed, and tells when some lines may have been
+missed. It gives up when the stream can't be reopened for 10 minutes.
+
+The --output-file flag also writes the lines, without colors, to the given
+file. When the file reaches --max-size megabytes (100 by default, 0 disables
+it), it's renamed with the current time appended to its name and compressed
+with gzip, and a new file is started. Use -q/--quiet to only write the lines
+to the file.`,
 		MinArgs: 0,
 	}
 	c.Assert((&appLog{}).Info(), gocheck.DeepEquals, expected)
@@ -188,6 +380,25 @@ func (s *S) TestAppLogBySource(c *gocheck.C) {
 	c.Assert(stdout.String(), gocheck.Equals, expected)
 }
 
+func (s *S) TestAppLogEscapesSourceAndUnit(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	fake := &cmdtest.FakeGuesser{Name: "hitthelights"}
+	command := appLog{GuessingCommand: cmd.GuessingCommand{G: fake}}
+	command.Flags().Parse(true, []string{"--source", "my source&x=1", "--unit", "a+b"})
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "[]", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			query := req.URL.Query()
+			return query.Get("source") == "my source&x=1" && query.Get("unit") == "a+b" &&
+				query.Get("x") == "" && query.Get("lines") == "10"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+}
+
 func (s *S) TestAppLogByUnit(c *gocheck.C) {
 	var stdout, stderr bytes.Buffer
 	t := time.Now()
@@ -272,16 +483,202 @@ func (s *S) TestAppLogWithFollow(c *gocheck.C) {
 	fake := &cmdtest.FakeGuesser{Name: "hitthelights"}
 	command := appLog{GuessingCommand: cmd.GuessingCommand{G: fake}}
 	command.Flags().Parse(true, []string{"--lines", "12", "-f"})
-	trans := &cmdtest.ConditionalTransport{
-		Transport: cmdtest.Transport{Message: string(result), Status: http.StatusOK},
-		CondFunc: func(req *http.Request) bool {
-			return req.URL.Query().Get("lines") == "12" && req.URL.Query().Get("follow") == "1"
+	trans := &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: string(result), Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return req.URL.Query().Get("lines") == "12" && req.URL.Query().Get("follow") == "1"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: "App not found", Status: http.StatusNotFound},
+				CondFunc:  func(req *http.Request) bool { return true },
+			},
 		},
 	}
+	old := logReconnectDelay
+	logReconnectDelay = time.Millisecond
+	defer func() { logReconnectDelay = old }()
 	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
 	err = command.Run(&context, client)
+	c.Assert(err, gocheck.ErrorMatches, "App not found")
+	c.Assert(stdout.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestAppLogFollowReconnects(c *gocheck.C) {
+	old := logReconnectDelay
+	logReconnectDelay = time.Millisecond
+	defer func() { logReconnectDelay = old }()
+	var stdout, stderr bytes.Buffer
+	t := time.Date(2015, 3, 10, 14, 0, 0, 0, time.UTC)
+	first, err := json.Marshal([]log{
+		{Date: t, Message: "starting", Source: "app"},
+		{Date: t.Add(time.Second), Message: "listening", Source: "app"},
+	})
+	c.Assert(err, gocheck.IsNil)
+	second, err := json.Marshal([]log{
+		{Date: t, Message: "starting", Source: "app"},
+		{Date: t.Add(time.Second), Message: "listening", Source: "app"},
+		{Date: t.Add(time.Second), Message: "ready", Source: "app"},
+		{Date: t.Add(2 * time.Second), Message: "GET /", Source: "app"},
+	})
 	c.Assert(err, gocheck.IsNil)
+	trans := &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: string(first) + "\n" + `[{"Date":`, Status: http.StatusOK},
+				CondFunc:  func(req *http.Request) bool { return req.URL.Query().Get("lines") == "10" },
+			},
+			{
+				Transport: cmdtest.Transport{Message: "restarting", Status: http.StatusServiceUnavailable},
+				CondFunc:  func(req *http.Request) bool { return true },
+			},
+			{
+				Transport: cmdtest.Transport{Message: string(second), Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return req.URL.Query().Get("lines") == "100" && req.URL.Query().Get("follow") == "1"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: "App not found", Status: http.StatusNotFound},
+				CondFunc:  func(req *http.Request) bool { return true },
+			},
+		},
+	}
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	command := appLog{}
+	command.Flags().Parse(true, []string{"-a", "myapp", "-f", "--no-color", "--timestamps", "utc"})
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.ErrorMatches, "App not found")
+	expected := `2015-03-10 14:00:00 +0000 [app]: starting
+2015-03-10 14:00:01 +0000 [app]: listening
+2015-03-10 14:00:01 +0000 [app]: ready
+2015-03-10 14:00:02 +0000 [app]: GET /
+`
 	c.Assert(stdout.String(), gocheck.Equals, expected)
+	expected = `The log stream was closed, reconnecting...
+Failed to reconnect: restarting. Retrying in 2ms...
+The log stream was closed, reconnecting...
+`
+	c.Assert(stderr.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestAppLogFollowOutOfOrderEntries(c *gocheck.C) {
+	old := logReconnectDelay
+	logReconnectDelay = time.Millisecond
+	defer func() { logReconnectDelay = old }()
+	var stdout, stderr bytes.Buffer
+	t := time.Date(2015, 3, 10, 14, 0, 0, 0, time.UTC)
+	first, err := json.Marshal([]log{
+		{Date: t.Add(2 * time.Second), Message: "GET /", Source: "app", Unit: "abc"},
+		{Date: t, Message: "starting", Source: "app", Unit: "def"},
+	})
+	c.Assert(err, gocheck.IsNil)
+	second, err := json.Marshal([]log{
+		{Date: t.Add(time.Second), Message: "listening", Source: "app", Unit: "def"},
+	})
+	c.Assert(err, gocheck.IsNil)
+	trans := &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: string(first) + "\n" + string(second), Status: http.StatusOK},
+				CondFunc:  func(req *http.Request) bool { return true },
+			},
+			{
+				Transport: cmdtest.Transport{Message: "App not found", Status: http.StatusNotFound},
+				CondFunc:  func(req *http.Request) bool { return true },
+			},
+		},
+	}
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	command := appLog{}
+	command.Flags().Parse(true, []string{"-a", "myapp", "-f", "--no-date", "--no-color"})
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.ErrorMatches, "App not found")
+	c.Assert(stdout.String(), gocheck.Equals, "[app][abc]: GET /\n[app][def]: starting\n[app][def]: listening\n")
+}
+
+func (s *S) TestAppLogFollowReportsGap(c *gocheck.C) {
+	old := logReconnectDelay
+	logReconnectDelay = time.Millisecond
+	defer func() { logReconnectDelay = old }()
+	var stdout, stderr bytes.Buffer
+	t := time.Date(2015, 3, 10, 14, 0, 0, 0, time.UTC)
+	first, err := json.Marshal([]log{{Date: t, Message: "starting", Source: "app"}})
+	c.Assert(err, gocheck.IsNil)
+	second, err := json.Marshal([]log{{Date: t.Add(time.Hour), Message: "GET /", Source: "app"}})
+	c.Assert(err, gocheck.IsNil)
+	trans := &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: string(first), Status: http.StatusOK},
+				CondFunc:  func(req *http.Request) bool { return true },
+			},
+			{
+				Transport: cmdtest.Transport{Message: string(second), Status: http.StatusOK},
+				CondFunc:  func(req *http.Request) bool { return true },
+			},
+			{
+				Transport: cmdtest.Transport{Message: "App not found", Status: http.StatusNotFound},
+				CondFunc:  func(req *http.Request) bool { return true },
+			},
+		},
+	}
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	command := appLog{}
+	command.Flags().Parse(true, []string{"-a", "myapp", "-f", "--no-date", "--no-color"})
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.ErrorMatches, "App not found")
+	c.Assert(stdout.String(), gocheck.Equals, "[app]: starting\n[app]: GET /\n")
+	expected := `The log stream was closed, reconnecting...
+The log stream was interrupted, some lines may be missing.
+The log stream was closed, reconnecting...
+`
+	c.Assert(stderr.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestAppLogFollowGivesUpReconnecting(c *gocheck.C) {
+	oldDelay, oldTimeout := logReconnectDelay, logReconnectTimeout
+	logReconnectDelay, logReconnectTimeout = time.Millisecond, 5*time.Millisecond
+	defer func() { logReconnectDelay, logReconnectTimeout = oldDelay, oldTimeout }()
+	var stdout, stderr bytes.Buffer
+	t := time.Date(2015, 3, 10, 14, 0, 0, 0, time.UTC)
+	first, err := json.Marshal([]log{{Date: t, Message: "starting", Source: "app"}})
+	c.Assert(err, gocheck.IsNil)
+	var requests int
+	trans := transportFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		if requests == 1 {
+			return &http.Response{Body: ioutil.NopCloser(bytes.NewReader(first)), StatusCode: http.StatusOK}, nil
+		}
+		return &http.Response{Body: ioutil.NopCloser(strings.NewReader("restarting")), StatusCode: http.StatusServiceUnavailable}, nil
+	})
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	command := appLog{}
+	command.Flags().Parse(true, []string{"-a", "myapp", "-f", "--no-date"})
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.ErrorMatches, "the log stream couldn't be reopened in 5ms: restarting")
+	c.Assert(requests > 1, gocheck.Equals, true)
+	dim := func(msg string) string { return cmd.Colorfy(msg, "white", "", "dim") + "\n" }
+	c.Assert(strings.HasPrefix(stderr.String(), dim("The log stream was closed, reconnecting...")), gocheck.Equals, true)
+	c.Assert(strings.Count(stderr.String(), "Failed to reconnect: restarting."), gocheck.Equals, requests-2)
 }
 
 func (s *S) TestAppLogFlagSet(c *gocheck.C) {
@@ -327,13 +724,13 @@ func (s *S) TestAppLogFlagSet(c *gocheck.C) {
 	app := flagset.Lookup("app")
 	c.Check(app, gocheck.NotNil)
 	c.Check(app.Name, gocheck.Equals, "app")
-	c.Check(app.Usage, gocheck.Equals, "The name of the app.")
+	c.Check(app.Usage, gocheck.Equals, "The name of the app. May be given multiple times")
 	c.Check(app.Value.String(), gocheck.Equals, "ashamed")
 	c.Check(app.DefValue, gocheck.Equals, "")
 	sapp := flagset.Lookup("a")
 	c.Check(sapp, gocheck.NotNil)
 	c.Check(sapp.Name, gocheck.Equals, "a")
-	c.Check(sapp.Usage, gocheck.Equals, "The name of the app.")
+	c.Check(sapp.Usage, gocheck.Equals, "The name of the app. May be given multiple times")
 	c.Check(sapp.Value.String(), gocheck.Equals, "ashamed")
 	c.Check(sapp.DefValue, gocheck.Equals, "")
 	follow := flagset.Lookup("follow")
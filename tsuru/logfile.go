@@ -0,0 +1,159 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tsuru/tsuru/fs"
+)
+
+const rotatedLogLayout = "20060102-150405.000"
+
+// rotatingFile is a file that, when about to grow beyond maxSize, is renamed
+// with the current time appended to its name and replaced by a new file.
+// Rotated files are compressed with gzip in the background, so writes don't
+// wait for them, and Close waits for the compressions to finish, returning
+// their first error. Writes are never split between files, and a zero maxSize
+// disables the rotation.
+type rotatingFile struct {
+	path        string
+	maxSize     int64
+	mu          sync.Mutex
+	file        fs.File
+	size        int64
+	err         error
+	compressing sync.WaitGroup
+	compressErr error
+}
+
+func openRotatingFile(path string, maxSize int64) (*rotatingFile, error) {
+	f := rotatingFile{path: path, maxSize: maxSize}
+	file, err := filesystem().OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	f.file = file
+	f.size = info.Size()
+	return &f, nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return 0, f.err
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if f.err = f.rotate(); f.err != nil {
+			return 0, f.err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	f.err = err
+	return n, err
+}
+
+// Err returns the error that stopped the writes to the file.
+func (f *rotatingFile) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	err := f.file.Close()
+	f.mu.Unlock()
+	f.compressing.Wait()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		err = f.compressErr
+	}
+	return err
+}
+
+func (f *rotatingFile) rotate() error {
+	err := f.file.Close()
+	if err != nil {
+		return err
+	}
+	// Names of rotated files sort in the order they were rotated.
+	now := time.Now()
+	rotated := f.path + "." + now.Format(rotatedLogLayout)
+	for f.exists(rotated) || f.exists(rotated+".gz") {
+		now = now.Add(time.Millisecond)
+		rotated = f.path + "." + now.Format(rotatedLogLayout)
+	}
+	err = filesystem().Rename(f.path, rotated)
+	if err != nil {
+		return err
+	}
+	f.compressing.Add(1)
+	go f.compress(rotated)
+	f.file, err = filesystem().OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	f.size = 0
+	return nil
+}
+
+// compress replaces a rotated file with its gzip compressed version, keeping
+// the rotated file when it fails.
+func (f *rotatingFile) compress(path string) {
+	defer f.compressing.Done()
+	err := gzipFile(path)
+	if err != nil {
+		f.mu.Lock()
+		if f.compressErr == nil {
+			f.compressErr = err
+		}
+		f.mu.Unlock()
+	}
+}
+
+func (f *rotatingFile) exists(path string) bool {
+	_, err := filesystem().Stat(path)
+	return !os.IsNotExist(err)
+}
+
+// gzipFile replaces a file with its gzip compressed version, adding .gz to
+// its name.
+func gzipFile(path string) error {
+	src, err := filesystem().Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := filesystem().OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	w := gzip.NewWriter(dst)
+	_, err = io.Copy(w, src)
+	if err == nil {
+		err = w.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		filesystem().Remove(path + ".gz")
+		return err
+	}
+	return filesystem().Remove(path)
+}
@@ -0,0 +1,183 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/cmd/cmdtest"
+	"github.com/tsuru/tsuru/fs"
+	"launchpad.net/gocheck"
+)
+
+func readGzipFile(c *gocheck.C, path string) string {
+	f, err := os.Open(path)
+	c.Assert(err, gocheck.IsNil)
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	c.Assert(err, gocheck.IsNil)
+	data, err := ioutil.ReadAll(r)
+	c.Assert(err, gocheck.IsNil)
+	return string(data)
+}
+
+func (s *S) TestRotatingFile(c *gocheck.C) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "incident.log")
+	err := ioutil.WriteFile(path, []byte("line 0\n"), 0644)
+	c.Assert(err, gocheck.IsNil)
+	f, err := openRotatingFile(path, 14)
+	c.Assert(err, gocheck.IsNil)
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n", "line 4\n"} {
+		_, err = f.Write([]byte(line))
+		c.Assert(err, gocheck.IsNil)
+	}
+	c.Assert(f.Close(), gocheck.IsNil)
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(string(data), gocheck.Equals, "line 4\n")
+	rotated, err := filepath.Glob(path + ".*.gz")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(rotated, gocheck.HasLen, 2)
+	sort.Strings(rotated)
+	c.Assert(readGzipFile(c, rotated[0]), gocheck.Equals, "line 0\nline 1\n")
+	c.Assert(readGzipFile(c, rotated[1]), gocheck.Equals, "line 2\nline 3\n")
+	uncompressed, err := filepath.Glob(path + ".*[0-9]")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(uncompressed, gocheck.HasLen, 0)
+}
+
+// gzipFs is a filesystem that waits for release to be closed before creating
+// compressed files, failing when err isn't nil.
+type gzipFs struct {
+	fs.OsFs
+	release chan struct{}
+	err     error
+}
+
+func (f *gzipFs) OpenFile(name string, flag int, perm os.FileMode) (fs.File, error) {
+	if strings.HasSuffix(name, ".gz") {
+		<-f.release
+		if f.err != nil {
+			return nil, f.err
+		}
+	}
+	return f.OsFs.OpenFile(name, flag, perm)
+}
+
+func (s *S) TestRotatingFileCompressesInBackground(c *gocheck.C) {
+	gfs := &gzipFs{release: make(chan struct{})}
+	fsystem = gfs
+	defer func() { fsystem = nil }()
+	path := filepath.Join(c.MkDir(), "incident.log")
+	f, err := openRotatingFile(path, 14)
+	c.Assert(err, gocheck.IsNil)
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n"} {
+		_, err = f.Write([]byte(line))
+		c.Assert(err, gocheck.IsNil)
+	}
+	uncompressed, err := filepath.Glob(path + ".*[0-9]")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(uncompressed, gocheck.HasLen, 1)
+	close(gfs.release)
+	c.Assert(f.Close(), gocheck.IsNil)
+	rotated, err := filepath.Glob(path + ".*.gz")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(rotated, gocheck.HasLen, 1)
+	c.Assert(readGzipFile(c, rotated[0]), gocheck.Equals, "line 1\nline 2\n")
+	uncompressed, err = filepath.Glob(path + ".*[0-9]")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(uncompressed, gocheck.HasLen, 0)
+}
+
+func (s *S) TestRotatingFileCompressionFailure(c *gocheck.C) {
+	gfs := &gzipFs{release: make(chan struct{}), err: errors.New("disk full")}
+	close(gfs.release)
+	fsystem = gfs
+	defer func() { fsystem = nil }()
+	path := filepath.Join(c.MkDir(), "incident.log")
+	f, err := openRotatingFile(path, 14)
+	c.Assert(err, gocheck.IsNil)
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n"} {
+		_, err = f.Write([]byte(line))
+		c.Assert(err, gocheck.IsNil)
+	}
+	c.Assert(f.Close(), gocheck.ErrorMatches, "disk full")
+	uncompressed, err := filepath.Glob(path + ".*[0-9]")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(uncompressed, gocheck.HasLen, 1)
+	data, err := ioutil.ReadFile(uncompressed[0])
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(string(data), gocheck.Equals, "line 1\nline 2\n")
+}
+
+func (s *S) TestRotatingFileWithoutMaxSize(c *gocheck.C) {
+	path := filepath.Join(c.MkDir(), "incident.log")
+	f, err := openRotatingFile(path, 0)
+	c.Assert(err, gocheck.IsNil)
+	for i := 0; i < 3; i++ {
+		_, err = f.Write([]byte("some line\n"))
+		c.Assert(err, gocheck.IsNil)
+	}
+	c.Assert(f.Close(), gocheck.IsNil)
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(string(data), gocheck.Equals, "some line\nsome line\nsome line\n")
+}
+
+func (s *S) TestAppLogOutputFile(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	t := time.Date(2015, 3, 10, 14, 0, 0, 0, time.UTC)
+	result, err := json.Marshal([]log{{Date: t, Message: "creating app lost", Source: "tsuru"}})
+	c.Assert(err, gocheck.IsNil)
+	path := filepath.Join(c.MkDir(), "incident.log")
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: &cmdtest.Transport{Message: string(result), Status: http.StatusOK}}, nil, manager)
+	command := appLog{}
+	command.Flags().Parse(true, []string{"-a", "lost", "--timestamps", "utc", "--output-file", path})
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	prefix := "2015-03-10 14:00:00 +0000 [tsuru]:"
+	c.Assert(stdout.String(), gocheck.Equals, cmd.Colorfy(prefix, "blue", "", "")+" creating app lost\n")
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(string(data), gocheck.Equals, prefix+" creating app lost\n")
+}
+
+func (s *S) TestAppLogOutputFileQuiet(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	t := time.Date(2015, 3, 10, 14, 0, 0, 0, time.UTC)
+	result, err := json.Marshal([]log{{Date: t, Message: "creating app lost", Source: "tsuru"}})
+	c.Assert(err, gocheck.IsNil)
+	path := filepath.Join(c.MkDir(), "incident.log")
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: &cmdtest.Transport{Message: string(result), Status: http.StatusOK}}, nil, manager)
+	command := appLog{}
+	command.Flags().Parse(true, []string{"-a", "lost", "--output", "json", "--output-file", path, "-q"})
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "")
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(string(data), gocheck.Equals, `{"date":"2015-03-10T14:00:00Z","source":"tsuru","message":"creating app lost"}`+"\n")
+}
+
+func (s *S) TestAppLogQuietWithoutOutputFile(c *gocheck.C) {
+	command := appLog{}
+	command.Flags().Parse(true, []string{"-a", "lost", "--quiet"})
+	err := command.Run(&cmd.Context{}, nil)
+	c.Assert(err, gocheck.ErrorMatches, "the --quiet flag requires --output-file")
+}
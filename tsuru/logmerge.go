@@ -0,0 +1,134 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tsuru/tsuru/cmd"
+)
+
+// logReorderWindow is how long entries of several apps are held before being
+// printed, so entries received a bit later can be printed before them.
+var logReorderWindow = 500 * time.Millisecond
+
+var appColors = []string{"cyan", "green", "yellow", "magenta", "red"}
+
+// appColor returns the color of the prefix of the lines of an app, which is
+// always the same for the same app.
+func appColor(appName string) string {
+	h := fnv.New32a()
+	h.Write([]byte(appName))
+	return appColors[h.Sum32()%uint32(len(appColors))]
+}
+
+// tailApps prints the logs of several apps at once, reading them
+// concurrently. Apps whose logs can't be read are reported without stopping
+// the others.
+func (c *appLog) tailApps(context *cmd.Context, client *cmd.Client, appNames []string, formatter logFormatter) error {
+	merger := &logMerger{out: context.Stdout, window: logReorderWindow}
+	appContext := *context
+	appContext.Stdout = &syncWriter{mu: &merger.mu, w: context.Stdout}
+	appContext.Stderr = &syncWriter{mu: &sync.Mutex{}, w: context.Stderr}
+	formatter.merger = merger
+	for _, name := range appNames {
+		if len(name) > formatter.appWidth {
+			formatter.appWidth = len(name)
+		}
+	}
+	errs := make(chan error, len(appNames))
+	for _, name := range appNames {
+		formatter.app = name
+		go func(name string, formatter logFormatter) {
+			err := c.tail(&appContext, client, name, formatter)
+			if err != nil {
+				fmt.Fprintf(appContext.Stderr, "Failed to read the logs of app %q: %s\n", name, err)
+			}
+			errs <- err
+		}(name, formatter)
+	}
+	ticker := time.NewTicker(merger.window / 2)
+	defer ticker.Stop()
+	var failures int
+	for running := len(appNames); running > 0; {
+		select {
+		case err := <-errs:
+			running--
+			if err != nil {
+				failures++
+			}
+		case <-ticker.C:
+			if err := merger.flush(false); err != nil {
+				return err
+			}
+		}
+	}
+	if err := merger.flush(true); err != nil {
+		return err
+	}
+	if failures > 0 {
+		return fmt.Errorf("failed to read the logs of %d of %d apps", failures, len(appNames))
+	}
+	return nil
+}
+
+// logMerger prints the entries of several log streams in the order of their
+// dates. Entries are held for a short window after being received, so an
+// entry of another stream delayed by the network is printed in its place.
+type logMerger struct {
+	out     io.Writer
+	window  time.Duration
+	mu      sync.Mutex
+	pending []mergedLog
+}
+
+type mergedLog struct {
+	log
+	formatter logFormatter
+	received  time.Time
+}
+
+func (m *logMerger) add(formatter logFormatter, l log) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := sort.Search(len(m.pending), func(i int) bool {
+		return m.pending[i].Date.After(l.Date)
+	})
+	m.pending = append(m.pending, mergedLog{})
+	copy(m.pending[i+1:], m.pending[i:])
+	m.pending[i] = mergedLog{log: l, formatter: formatter, received: time.Now()}
+}
+
+// flush prints the entries held for longer than the window, or all of them.
+func (m *logMerger) flush(all bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deadline := time.Now().Add(-m.window)
+	for len(m.pending) > 0 && (all || !m.pending[0].received.After(deadline)) {
+		entry := m.pending[0]
+		m.pending = m.pending[1:]
+		if err := entry.formatter.print(m.out, entry.log); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncWriter serializes writes made by concurrent goroutines.
+type syncWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
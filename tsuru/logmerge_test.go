@@ -0,0 +1,140 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru/cmd"
+	"launchpad.net/gocheck"
+)
+
+func logsTransport(c *gocheck.C, logs map[string][]log, apps string) transportFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		var body string
+		status := http.StatusOK
+		if req.URL.Path == "/apps" {
+			body = apps
+		} else {
+			name := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/apps/"), "/log")
+			if entries, ok := logs[name]; ok {
+				data, err := json.Marshal(entries)
+				c.Assert(err, gocheck.IsNil)
+				body = string(data)
+			} else {
+				body, status = "App not found", http.StatusNotFound
+			}
+		}
+		return &http.Response{Body: ioutil.NopCloser(strings.NewReader(body)), StatusCode: status}, nil
+	}
+}
+
+func (s *S) TestAppLogMultipleApps(c *gocheck.C) {
+	old := logReorderWindow
+	logReorderWindow = time.Millisecond
+	defer func() { logReorderWindow = old }()
+	t := time.Date(2015, 3, 10, 14, 0, 0, 0, time.UTC)
+	logs := map[string][]log{
+		"api": {
+			{Date: t, Message: "GET /users", Source: "app"},
+			{Date: t.Add(2 * time.Second), Message: "GET /users/1", Source: "app"},
+		},
+		"worker": {
+			{Date: t.Add(time.Second), Message: "sending emails", Source: "app"},
+		},
+	}
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: logsTransport(c, logs, "")}, nil, manager)
+	command := appLog{}
+	command.Flags().Parse(true, []string{"-a", "api", "-a", "worker", "--no-color", "--timestamps", "utc"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	expected := `api    2015-03-10 14:00:00 +0000 [app]: GET /users
+worker 2015-03-10 14:00:01 +0000 [app]: sending emails
+api    2015-03-10 14:00:02 +0000 [app]: GET /users/1
+`
+	c.Assert(stdout.String(), gocheck.Equals, expected)
+	c.Assert(stderr.String(), gocheck.Equals, "")
+}
+
+func (s *S) TestAppLogTeam(c *gocheck.C) {
+	old := logReorderWindow
+	logReorderWindow = time.Millisecond
+	defer func() { logReorderWindow = old }()
+	t := time.Date(2015, 3, 10, 14, 0, 0, 0, time.UTC)
+	logs := map[string][]log{
+		"api":    {{Date: t, Message: "GET /users", Source: "app"}},
+		"worker": {{Date: t.Add(time.Second), Message: "sending emails", Source: "app"}},
+	}
+	apps := `[{"name":"api","teamowner":"payments"},{"name":"blog","teamowner":"marketing"},{"name":"worker","teams":["payments"]}]`
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: logsTransport(c, logs, apps)}, nil, manager)
+	command := appLog{}
+	command.Flags().Parse(true, []string{"--team", "payments", "--output", "json", "--no-date"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	expected := `{"app":"api","source":"app","message":"GET /users"}
+{"app":"worker","source":"app","message":"sending emails"}
+`
+	c.Assert(stdout.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestAppLogTeamWithoutApps(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: logsTransport(c, nil, `[{"name":"blog","teamowner":"marketing"}]`)}, nil, manager)
+	command := appLog{}
+	command.Flags().Parse(true, []string{"--team", "payments"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.ErrorMatches, `team "payments" has no apps`)
+}
+
+func (s *S) TestAppLogMultipleAppsFailure(c *gocheck.C) {
+	old := logReorderWindow
+	logReorderWindow = time.Millisecond
+	defer func() { logReorderWindow = old }()
+	t := time.Date(2015, 3, 10, 14, 0, 0, 0, time.UTC)
+	logs := map[string][]log{
+		"api": {{Date: t, Message: "GET /users", Source: "app"}},
+	}
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: logsTransport(c, logs, "")}, nil, manager)
+	command := appLog{}
+	command.Flags().Parse(true, []string{"-a", "api", "-a", "wroker", "--no-color", "--no-date"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.ErrorMatches, "failed to read the logs of 1 of 2 apps")
+	c.Assert(stdout.String(), gocheck.Equals, "api    [app]: GET /users\n")
+	c.Assert(stderr.String(), gocheck.Equals, `Failed to read the logs of app "wroker": App not found`+"\n")
+}
+
+func (s *S) TestLogMergerOrdersByDate(c *gocheck.C) {
+	var out bytes.Buffer
+	t := time.Date(2015, 3, 10, 14, 0, 0, 0, time.UTC)
+	merger := logMerger{out: &out, window: time.Hour}
+	formatter := logFormatter{noColor: true, noDate: true, app: "api", appWidth: 6}
+	merger.add(formatter, log{Date: t.Add(time.Second), Message: "second", Source: "app"})
+	merger.add(formatter, log{Date: t.Add(2 * time.Second), Message: "third", Source: "app"})
+	formatter.app = "worker"
+	merger.add(formatter, log{Date: t, Message: "first", Source: "app"})
+	err := merger.flush(false)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(out.String(), gocheck.Equals, "")
+	err = merger.flush(true)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(out.String(), gocheck.Equals, "worker [app]: first\napi    [app]: second\napi    [app]: third\n")
+}
+
+func (s *S) TestAppColor(c *gocheck.C) {
+	c.Assert(appColor("api"), gocheck.Equals, appColor("api"))
+	c.Assert(in(appColor("worker"), appColors), gocheck.Equals, true)
+}
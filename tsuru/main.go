@@ -5,6 +5,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/tsuru/tsuru/cmd"
@@ -21,46 +22,49 @@ func buildManager(name string) *cmd.Manager {
 		return command.Run(context, nil)
 	}
 	m := cmd.BuildBaseManager(name, version, header, lookup)
-	m.RegisterDeprecated(&appRun{}, "run")
-	m.Register(&appInfo{})
+	// Commands that act on an app take it from the project file, when it
+	// isn't given with --app.
+	guessing := cmd.GuessingCommand{G: projectGuesser{}}
+	m.RegisterDeprecated(&appRun{GuessingCommand: guessing}, "run")
+	m.Register(&appInfo{GuessingCommand: guessing})
 	m.Register(&appCreate{})
-	m.Register(&appRemove{})
-	m.Register(&unitAdd{})
-	m.Register(&unitRemove{})
-	m.Register(appList{})
-	m.RegisterDeprecated(&appLog{}, "log")
-	m.Register(&appGrant{})
-	m.Register(&appRevoke{})
-	m.RegisterDeprecated(&appRestart{}, "restart")
-	m.RegisterDeprecated(&appStart{}, "start")
-	m.RegisterDeprecated(&appStop{}, "stop")
-	m.RegisterDeprecated(&cnameAdd{}, "add-cname")
-	m.RegisterDeprecated(&cnameRemove{}, "remove-cname")
-	m.Register(&envGet{})
-	m.Register(&envSet{})
-	m.Register(&envUnset{})
+	m.Register(&appRemove{GuessingCommand: guessing})
+	m.Register(&unitAdd{GuessingCommand: guessing})
+	m.Register(&unitRemove{GuessingCommand: guessing})
+	m.Register(&appList{})
+	m.RegisterDeprecated(&appLog{GuessingCommand: guessing}, "log")
+	m.Register(&appGrant{GuessingCommand: guessing})
+	m.Register(&appRevoke{GuessingCommand: guessing})
+	m.RegisterDeprecated(&appRestart{GuessingCommand: guessing}, "restart")
+	m.RegisterDeprecated(&appStart{GuessingCommand: guessing}, "start")
+	m.RegisterDeprecated(&appStop{GuessingCommand: guessing}, "stop")
+	m.RegisterDeprecated(&cnameAdd{GuessingCommand: guessing}, "add-cname")
+	m.RegisterDeprecated(&cnameRemove{GuessingCommand: guessing}, "remove-cname")
+	m.Register(&envGet{GuessingCommand: guessing})
+	m.Register(&envSet{GuessingCommand: guessing})
+	m.Register(&envUnset{GuessingCommand: guessing})
 	m.Register(&keyAdd{})
 	m.Register(&keyRemove{})
 	m.Register(&keyList{})
-	m.Register(serviceList{})
+	m.Register(&serviceList{})
 	m.Register(&serviceAdd{})
 	m.Register(&serviceRemove{})
 	m.Register(serviceDoc{})
-	m.Register(serviceInfo{})
+	m.Register(&serviceInfo{})
 	m.Register(serviceInstanceStatus{})
-	m.RegisterDeprecated(&serviceBind{}, "bind")
-	m.RegisterDeprecated(&serviceUnbind{}, "unbind")
-	m.Register(platformList{})
+	m.RegisterDeprecated(&serviceBind{GuessingCommand: guessing}, "bind")
+	m.RegisterDeprecated(&serviceUnbind{GuessingCommand: guessing}, "unbind")
+	m.Register(&platformList{})
 	m.Register(&pluginInstall{})
 	m.Register(&pluginRemove{})
 	m.Register(&pluginList{})
 	m.RegisterDeprecated(&appSwap{}, "swap")
-	m.RegisterDeprecated(&appDeploy{}, "deploy")
+	m.RegisterDeprecated(&appDeploy{GuessingCommand: guessing}, "deploy")
 	m.Register(&planList{})
-	m.Register(&SetTeamOwner{})
-	m.Register(&autoScaleEnable{})
-	m.Register(&autoScaleDisable{})
-	m.Register(&autoScaleConfig{})
+	m.Register(&SetTeamOwner{GuessingCommand: guessing})
+	m.Register(&autoScaleEnable{GuessingCommand: guessing})
+	m.Register(&autoScaleDisable{GuessingCommand: guessing})
+	m.Register(&autoScaleConfig{GuessingCommand: guessing})
 	m.Register(&userCreate{})
 	m.Register(&resetPassword{})
 	m.Register(&userRemove{})
@@ -69,18 +73,31 @@ func buildManager(name string) *cmd.Manager {
 	m.Register(&teamList{})
 	m.Register(&teamUserAdd{})
 	m.Register(&teamUserRemove{})
-	m.Register(teamUserList{})
+	m.Register(&teamUserList{})
 	m.Register(&changePassword{})
 	m.Register(&showAPIToken{})
 	m.Register(&regenerateAPIToken{})
-	m.Register(&appDeployList{})
-	m.Register(&appDeployRollback{})
-	m.RegisterDeprecated(&cmd.ShellToContainerCmd{}, "ssh")
+	m.Register(&appDeployList{GuessingCommand: guessing})
+	m.Register(&appDeployRollback{GuessingCommand: guessing})
+	m.Register(&appDeployDiff{GuessingCommand: guessing})
+	m.Register(&appDeployInfo{GuessingCommand: guessing})
+	m.RegisterDeprecated(&cmd.ShellToContainerCmd{GuessingCommand: guessing}, "ssh")
 	return m
 }
 
 func main() {
 	name := cmd.ExtractProgramName(os.Args[0])
+	wd, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		finisher().Exit(1)
+	}
+	source, err := pinProjectTarget(wd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		finisher().Exit(1)
+	}
 	manager := buildManager(name)
+	printTarget(os.Stderr, manager, os.Args[1:], source)
 	manager.Run(os.Args[1:])
 }
@@ -40,7 +40,7 @@ func (s *S) TestAppListIsRegistered(c *gocheck.C) {
 	manager := buildManager("tsuru")
 	list, ok := manager.Commands["app-list"]
 	c.Assert(ok, gocheck.Equals, true)
-	c.Assert(list, gocheck.FitsTypeOf, appList{})
+	c.Assert(list, gocheck.FitsTypeOf, &appList{})
 }
 
 func (s *S) TestAppGrantIsRegistered(c *gocheck.C) {
@@ -136,7 +136,7 @@ func (s *S) TestServiceListIsRegistered(c *gocheck.C) {
 	manager := buildManager("tsuru")
 	list, ok := manager.Commands["service-list"]
 	c.Assert(ok, gocheck.Equals, true)
-	c.Assert(list, gocheck.FitsTypeOf, serviceList{})
+	c.Assert(list, gocheck.FitsTypeOf, &serviceList{})
 }
 
 func (s *S) TestServiceAddIsRegistered(c *gocheck.C) {
@@ -186,7 +186,7 @@ func (s *S) TestServiceInfoIsRegistered(c *gocheck.C) {
 	manager := buildManager("tsuru")
 	info, ok := manager.Commands["service-info"]
 	c.Assert(ok, gocheck.Equals, true)
-	c.Assert(info, gocheck.FitsTypeOf, serviceInfo{})
+	c.Assert(info, gocheck.FitsTypeOf, &serviceInfo{})
 }
 
 func (s *S) TestServiceInstanceStatusIsRegistered(c *gocheck.C) {
@@ -243,7 +243,7 @@ func (s *S) TestPlatformListIsRegistered(c *gocheck.C) {
 	manager := buildManager("tsuru")
 	plat, ok := manager.Commands["platform-list"]
 	c.Assert(ok, gocheck.Equals, true)
-	c.Assert(plat, gocheck.FitsTypeOf, platformList{})
+	c.Assert(plat, gocheck.FitsTypeOf, &platformList{})
 }
 
 func (s *S) TestAppSwapIsRegistered(c *gocheck.C) {
@@ -397,7 +397,7 @@ func (s *S) TestTeamUserListIsRegistered(c *gocheck.C) {
 	manager := buildManager("tsuru")
 	listuser, ok := manager.Commands["team-user-list"]
 	c.Assert(ok, gocheck.Equals, true)
-	c.Assert(listuser, gocheck.FitsTypeOf, teamUserList{})
+	c.Assert(listuser, gocheck.FitsTypeOf, &teamUserList{})
 }
 
 func (s *S) TestUserCreateIsRegistered(c *gocheck.C) {
@@ -420,3 +420,26 @@ func (s *S) TestTeamListIsRegistered(c *gocheck.C) {
 	c.Assert(ok, gocheck.Equals, true)
 	c.Assert(list, gocheck.FitsTypeOf, &teamList{})
 }
+
+func (s *S) TestAppDeployDiffIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	diff, ok := manager.Commands["app-deploy-diff"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(diff, gocheck.FitsTypeOf, &appDeployDiff{})
+}
+
+func (s *S) TestAppDeployInfoIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	info, ok := manager.Commands["app-deploy-info"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(info, gocheck.FitsTypeOf, &appDeployInfo{})
+}
+
+func (s *S) TestCommandsGuessAppFromProject(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	c.Check(manager.Commands["app-info"].(*appInfo).G, gocheck.FitsTypeOf, projectGuesser{})
+	c.Check(manager.Commands["app-deploy"].(*appDeploy).G, gocheck.FitsTypeOf, projectGuesser{})
+	c.Check(manager.Commands["env-set"].(*envSet).G, gocheck.FitsTypeOf, projectGuesser{})
+	c.Check(manager.Commands["service-bind"].(*serviceBind).G, gocheck.FitsTypeOf, projectGuesser{})
+	c.Check(manager.Commands["app-log"].(*appLog).G, gocheck.FitsTypeOf, projectGuesser{})
+}
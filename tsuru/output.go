@@ -0,0 +1,139 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v1"
+	"launchpad.net/gnuflag"
+)
+
+// outputCommand is embedded by the commands that, besides tables, can print
+// the data they get from the API as JSON, as YAML, through a template or as
+// a plain list of identifiers, to be consumed by scripts.
+type outputCommand struct {
+	fs     *gnuflag.FlagSet
+	asJSON bool
+	asYAML bool
+	format string
+	quiet  bool
+}
+
+func (c *outputCommand) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("", gnuflag.ContinueOnError)
+		c.fs.BoolVar(&c.asJSON, "json", false, "Print the output as JSON")
+		c.fs.BoolVar(&c.asYAML, "yaml", false, "Print the output as YAML")
+		c.fs.StringVar(&c.format, "format", "", "Print each item with the given Go template, like '{{.Name}}'")
+		quiet := "Print only the identifiers of the items, one per line"
+		c.fs.BoolVar(&c.quiet, "quiet", false, quiet)
+		c.fs.BoolVar(&c.quiet, "q", false, quiet)
+		c.fs.BoolVar(&c.quiet, "no-headers", false, quiet)
+	}
+	return c.fs
+}
+
+// structured tells whether the output should be in one of the formats of
+// outputCommand, instead of text.
+func (c *outputCommand) structured() bool {
+	return c.asJSON || c.asYAML || c.format != "" || c.quiet
+}
+
+// templateFuncs are the functions available to --format templates, besides
+// the builtin ones.
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// write prints v in the format given in the command line. Templates are
+// executed once for each item when v is a list, and ids are the identifiers
+// printed by --quiet. The YAML document is converted from the JSON one, so
+// both formats have the same keys.
+func (c *outputCommand) write(w io.Writer, v interface{}, ids []string) error {
+	var chosen int
+	for _, set := range []bool{c.asJSON, c.asYAML, c.format != "", c.quiet} {
+		if set {
+			chosen++
+		}
+	}
+	if chosen > 1 {
+		return errors.New("You can't use more than one of --json, --yaml, --format and --quiet.")
+	}
+	if c.quiet {
+		for _, id := range ids {
+			fmt.Fprintln(w, id)
+		}
+		return nil
+	}
+	if c.format != "" {
+		return c.execute(w, v)
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if c.asYAML {
+		var doc interface{}
+		err = yaml.Unmarshal(data, &doc)
+		if err != nil {
+			return err
+		}
+		data, err = yaml.Marshal(doc)
+		if err != nil {
+			return err
+		}
+	} else {
+		data = append(data, '\n')
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// execute runs the --format template against v or, when v is a list, against
+// each of its items, printing a line for each execution. Items are given by
+// reference, so the template may call their methods.
+func (c *outputCommand) execute(w io.Writer, v interface{}) error {
+	tmpl, err := template.New("format").Funcs(templateFuncs).Parse(c.format)
+	if err != nil {
+		return fmt.Errorf("invalid format: %s", err)
+	}
+	items := []interface{}{v}
+	if value := reflect.ValueOf(v); value.Kind() == reflect.Slice {
+		items = make([]interface{}, value.Len())
+		for i := range items {
+			item := value.Index(i)
+			if item.Kind() == reflect.Struct {
+				item = item.Addr()
+			}
+			items[i] = item.Interface()
+		}
+	}
+	var buf bytes.Buffer
+	for _, item := range items {
+		buf.Reset()
+		err = tmpl.Execute(&buf, item)
+		if err != nil {
+			return fmt.Errorf("invalid format: %s", err)
+		}
+		buf.WriteByte('\n')
+		_, err = w.Write(buf.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"launchpad.net/gocheck"
+)
+
+type outputDoc struct {
+	Name  string
+	Units []string
+	Ready bool
+}
+
+func (s *S) TestOutputCommandFlags(c *gocheck.C) {
+	var command outputCommand
+	err := command.Flags().Parse(true, []string{"--json"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(command.asJSON, gocheck.Equals, true)
+	c.Assert(command.asYAML, gocheck.Equals, false)
+	c.Assert(command.structured(), gocheck.Equals, true)
+}
+
+func (s *S) TestOutputCommandWriteJSON(c *gocheck.C) {
+	command := outputCommand{asJSON: true}
+	var buf bytes.Buffer
+	err := command.write(&buf, []outputDoc{{Name: "app1", Units: []string{"app1/0"}, Ready: true}}, nil)
+	c.Assert(err, gocheck.IsNil)
+	expected := `[
+  {
+    "Name": "app1",
+    "Units": [
+      "app1/0"
+    ],
+    "Ready": true
+  }
+]
+`
+	c.Assert(buf.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestOutputCommandWriteYAML(c *gocheck.C) {
+	command := outputCommand{asYAML: true}
+	var buf bytes.Buffer
+	err := command.write(&buf, []outputDoc{{Name: "app1", Units: []string{"app1/0"}, Ready: true}}, nil)
+	c.Assert(err, gocheck.IsNil)
+	expected := `- Name: app1
+  Ready: true
+  Units:
+  - app1/0
+`
+	c.Assert(buf.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestOutputCommandWriteJSONAndYAML(c *gocheck.C) {
+	command := outputCommand{asJSON: true, asYAML: true}
+	var buf bytes.Buffer
+	err := command.write(&buf, []outputDoc{}, nil)
+	c.Assert(err, gocheck.ErrorMatches, "You can't use more than one of --json, --yaml, --format and --quiet.")
+	c.Assert(buf.String(), gocheck.Equals, "")
+}
+
+func (d *outputDoc) Summary() string {
+	return fmt.Sprintf("%s (%d units)", d.Name, len(d.Units))
+}
+
+func (s *S) TestOutputCommandWriteFormat(c *gocheck.C) {
+	command := outputCommand{format: "{{.Summary}} {{join .Units \",\"}}"}
+	docs := []outputDoc{
+		{Name: "app1", Units: []string{"app1/0", "app1/1"}},
+		{Name: "app2"},
+	}
+	var buf bytes.Buffer
+	err := command.write(&buf, docs, nil)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Equals, "app1 (2 units) app1/0,app1/1\napp2 (0 units) \n")
+}
+
+func (s *S) TestOutputCommandWriteFormatSingleItem(c *gocheck.C) {
+	command := outputCommand{format: "{{.Name}}: {{json .Units}}"}
+	var buf bytes.Buffer
+	err := command.write(&buf, &outputDoc{Name: "app1", Units: []string{"app1/0"}}, nil)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Equals, "app1: [\"app1/0\"]\n")
+}
+
+func (s *S) TestOutputCommandWriteInvalidFormat(c *gocheck.C) {
+	command := outputCommand{format: "{{.Name"}
+	var buf bytes.Buffer
+	err := command.write(&buf, []outputDoc{}, nil)
+	c.Assert(err, gocheck.ErrorMatches, "invalid format: .*")
+	command = outputCommand{format: "{{.Missing}}"}
+	err = command.write(&buf, []outputDoc{{Name: "app1"}}, nil)
+	c.Assert(err, gocheck.ErrorMatches, "invalid format: .*")
+}
+
+func (s *S) TestOutputCommandWriteQuiet(c *gocheck.C) {
+	var command outputCommand
+	err := command.Flags().Parse(true, []string{"--no-headers"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(command.structured(), gocheck.Equals, true)
+	var buf bytes.Buffer
+	err = command.write(&buf, []outputDoc{{Name: "app1"}, {Name: "app2"}}, []string{"app1", "app2"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Equals, "app1\napp2\n")
+}
@@ -5,9 +5,7 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"net/http"
 	"strconv"
 
 	tsuruapp "github.com/tsuru/tsuru/app"
@@ -16,6 +14,7 @@ import (
 )
 
 type planList struct {
+	outputCommand
 	human bool
 	fs    *gnuflag.FlagSet
 }
@@ -26,6 +25,7 @@ func (c *planList) Flags() *gnuflag.FlagSet {
 		human := "Humanized units for memory and swap."
 		c.fs.BoolVar(&c.human, "human", false, human)
 		c.fs.BoolVar(&c.human, "h", false, human)
+		c.fs = cmd.MergeFlagSet(c.fs, c.outputCommand.Flags())
 	}
 	return c.fs
 }
@@ -33,7 +33,7 @@ func (c *planList) Flags() *gnuflag.FlagSet {
 func (c *planList) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "plan-list",
-		Usage:   "plan-list [--human]",
+		Usage:   "plan-list [--human] [--json | --yaml | --format <template> | -q/--quiet]",
 		Desc:    `List available plans that can be used when creating an app.`,
 		MinArgs: 0,
 	}
@@ -62,23 +62,23 @@ func renderPlans(plans []tsuruapp.Plan, isHuman bool) string {
 }
 
 func (c *planList) Run(context *cmd.Context, client *cmd.Client) error {
-	url, err := cmd.GetURL("/plans")
+	api, err := apiClient(client)
 	if err != nil {
 		return err
 	}
-	request, err := http.NewRequest("GET", url, nil)
+	plans, err := api.ListPlans()
 	if err != nil {
 		return err
 	}
-	var plans []tsuruapp.Plan
-	resp, err := client.Do(request)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	err = json.NewDecoder(resp.Body).Decode(&plans)
-	if err != nil {
-		return err
+	if c.structured() {
+		if plans == nil {
+			plans = []tsuruapp.Plan{}
+		}
+		names := make([]string, len(plans))
+		for i, p := range plans {
+			names[i] = p.Name
+		}
+		return c.write(context.Stdout, plans, names)
 	}
 	if len(plans) == 0 {
 		fmt.Fprintln(context.Stdout, "No plans available.")
@@ -16,7 +16,7 @@ import (
 func (s *S) TestPlanListInfo(c *gocheck.C) {
 	expected := &cmd.Info{
 		Name:    "plan-list",
-		Usage:   "plan-list [--human]",
+		Usage:   "plan-list [--human] [--json | --yaml | --format <template> | -q/--quiet]",
 		Desc:    "List available plans that can be used when creating an app.",
 		MinArgs: 0,
 	}
@@ -85,3 +85,30 @@ func (s *S) TestPlanListHuman(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(stdout.String(), gocheck.Equals, expected)
 }
+
+func (s *S) TestPlanListYAML(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	result := `[{"name":"test","memory":536870912,"swap":268435456,"cpushare":100,"router":"hipache","default":true}]`
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: &cmdtest.Transport{Message: result, Status: http.StatusOK}}, nil, manager)
+	command := planList{}
+	err := command.Flags().Parse(true, []string{"--yaml"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Matches, `(?s)- .*name: test\n.*`)
+	c.Assert(stdout.String(), gocheck.Matches, `(?s).*  memory: 536870912\n.*`)
+	c.Assert(stdout.String(), gocheck.Matches, `(?s).*  default: true\n.*`)
+}
+
+func (s *S) TestPlanListJSONWithoutPlans(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: &cmdtest.Transport{Message: "[]", Status: http.StatusOK}}, nil, manager)
+	command := planList{}
+	err := command.Flags().Parse(true, []string{"--json"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "[]\n")
+}
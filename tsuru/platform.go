@@ -17,9 +17,11 @@ type platform struct {
 	Name string
 }
 
-type platformList struct{}
+type platformList struct {
+	outputCommand
+}
 
-func (platformList) Run(context *cmd.Context, client *cmd.Client) error {
+func (c platformList) Run(context *cmd.Context, client *cmd.Client) error {
 	url, err := cmd.GetURL("/platforms")
 	if err != nil {
 		return err
@@ -38,6 +40,16 @@ func (platformList) Run(context *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return err
 	}
+	if c.structured() {
+		if platforms == nil {
+			platforms = []platform{}
+		}
+		names := make([]string, len(platforms))
+		for i, p := range platforms {
+			names[i] = p.Name
+		}
+		return c.write(context.Stdout, platforms, names)
+	}
 	if len(platforms) == 0 {
 		fmt.Fprintln(context.Stdout, "No platforms available.")
 		return nil
@@ -56,7 +68,7 @@ func (platformList) Run(context *cmd.Context, client *cmd.Client) error {
 func (platformList) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "platform-list",
-		Usage:   "platform-list",
+		Usage:   "platform-list [--json | --yaml | --format <template> | -q/--quiet]",
 		Desc:    "Display the list of available platforms.",
 		MinArgs: 0,
 	}
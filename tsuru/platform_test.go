@@ -52,7 +52,7 @@ func (s *S) TestPlatformListEmpty(c *gocheck.C) {
 func (s *S) TestPlatformListInfo(c *gocheck.C) {
 	expected := &cmd.Info{
 		Name:    "platform-list",
-		Usage:   "platform-list",
+		Usage:   "platform-list [--json | --yaml | --format <template> | -q/--quiet]",
 		Desc:    "Display the list of available platforms.",
 		MinArgs: 0,
 	}
@@ -62,3 +62,25 @@ func (s *S) TestPlatformListInfo(c *gocheck.C) {
 func (s *S) TestPlatformListIsACommand(c *gocheck.C) {
 	var _ cmd.Command = platformList{}
 }
+
+func (s *S) TestPlatformListJSON(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	result := `[{"Name":"ruby"},{"Name":"python"}]`
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: &cmdtest.Transport{Message: result, Status: http.StatusOK}}, nil, manager)
+	command := platformList{}
+	err := command.Flags().Parse(true, []string{"--json"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	expected := `[
+  {
+    "Name": "ruby"
+  },
+  {
+    "Name": "python"
+  }
+]
+`
+	c.Assert(stdout.String(), gocheck.Equals, expected)
+}
@@ -0,0 +1,177 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const progressBarWidth = 20
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && terminal.IsTerminal(int(f.Fd()))
+}
+
+// uploadProgress reports the progress of a deploy upload. The completion and
+// the estimated time left come from the bytes written to it, which are the
+// contents of the files being archived, as the total size of the compressed
+// archive isn't known before it's generated. The amount sent and the transfer
+// rate come from the bytes written through the writer returned by sent,
+// which wraps the body of the request.
+//
+// On terminals, the progress is redrawn in place a few times per second.
+// Otherwise, a plain line is printed every few seconds, so logs of CI systems
+// stay readable.
+type uploadProgress struct {
+	out      io.Writer
+	total    int64
+	archived int64
+	uploaded int64
+	tty      bool
+	interval time.Duration
+	begin    time.Time
+	done     chan struct{}
+	finished chan struct{}
+}
+
+func newUploadProgress(out io.Writer, total int64) *uploadProgress {
+	p := uploadProgress{out: out, total: total, tty: isTerminal(out)}
+	if p.tty {
+		p.interval = 200 * time.Millisecond
+	} else {
+		p.interval = 10 * time.Second
+	}
+	return &p
+}
+
+func (p *uploadProgress) Write(b []byte) (int, error) {
+	atomic.AddInt64(&p.archived, int64(len(b)))
+	return len(b), nil
+}
+
+// sent returns a writer that counts the bytes of the archive sent to the
+// server through w.
+func (p *uploadProgress) sent(w io.Writer) io.Writer {
+	return &countingWriter{w: w, n: &p.uploaded}
+}
+
+func (p *uploadProgress) start() {
+	p.begin = time.Now()
+	p.done = make(chan struct{})
+	p.finished = make(chan struct{})
+	if p.tty {
+		p.report()
+	}
+	go p.run()
+}
+
+func (p *uploadProgress) run() {
+	defer close(p.finished)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.report()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *uploadProgress) report() {
+	line := p.line(atomic.LoadInt64(&p.archived), atomic.LoadInt64(&p.uploaded), time.Since(p.begin))
+	if p.tty {
+		fmt.Fprintf(p.out, "\r%s\x1b[K", line)
+	} else {
+		fmt.Fprintln(p.out, line)
+	}
+}
+
+// stop ends the reporting, leaving a summary of the upload in the last line
+// of the output, without a line break.
+func (p *uploadProgress) stop() {
+	close(p.done)
+	<-p.finished
+	summary := p.summary(atomic.LoadInt64(&p.archived), atomic.LoadInt64(&p.uploaded), time.Since(p.begin))
+	if p.tty {
+		fmt.Fprintf(p.out, "\r%s\x1b[K", summary)
+	} else {
+		fmt.Fprint(p.out, summary)
+	}
+}
+
+func (p *uploadProgress) percent(archived int64) int64 {
+	if p.total <= 0 || archived >= p.total {
+		return 100
+	}
+	return archived * 100 / p.total
+}
+
+func (p *uploadProgress) line(archived, sent int64, elapsed time.Duration) string {
+	percent := p.percent(archived)
+	eta := "--:--"
+	if rate := transferRate(archived, elapsed); rate > 0 {
+		remaining := p.total - archived
+		if remaining < 0 {
+			remaining = 0
+		}
+		eta = formatDuration(time.Duration(remaining/rate) * time.Second)
+	}
+	rate := formatSize(transferRate(sent, elapsed))
+	if !p.tty {
+		return fmt.Sprintf("Uploading files: %d%% of %s (%s sent) %s/s ETA %s", percent, formatSize(p.total), formatSize(sent), rate, eta)
+	}
+	filled := int(percent * progressBarWidth / 100)
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	return fmt.Sprintf("Uploading files [%s] %3d%% of %s, %s sent %s/s ETA %s", bar, percent, formatSize(p.total), formatSize(sent), rate, eta)
+}
+
+func (p *uploadProgress) summary(archived, sent int64, elapsed time.Duration) string {
+	return fmt.Sprintf("Uploading files: %d%% of %s (%s sent) %s/s in %s...",
+		p.percent(archived), formatSize(p.total), formatSize(sent),
+		formatSize(transferRate(sent, elapsed)), formatDuration(elapsed))
+}
+
+// countingWriter adds the number of bytes written through it to n.
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	atomic.AddInt64(w.n, int64(n))
+	return n, err
+}
+
+// transferRate returns the number of bytes transferred per second.
+func transferRate(n int64, elapsed time.Duration) int64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(n) / elapsed.Seconds())
+}
+
+func formatDuration(d time.Duration) string {
+	seconds := int64(d / time.Second)
+	minutes := seconds / 60
+	seconds = seconds % 60
+	if minutes >= 60 {
+		return fmt.Sprintf("%d:%02d:%02d", minutes/60, minutes%60, seconds)
+	}
+	return fmt.Sprintf("%d:%02d", minutes, seconds)
+}
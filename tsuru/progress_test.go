@@ -0,0 +1,75 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"time"
+
+	"launchpad.net/gocheck"
+)
+
+func (s *S) TestUploadProgressCountsWrites(c *gocheck.C) {
+	p := newUploadProgress(&bytes.Buffer{}, 100)
+	n, err := p.Write([]byte("hello"))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 5)
+	p.Write([]byte("world"))
+	c.Assert(p.archived, gocheck.Equals, int64(10))
+	c.Assert(p.uploaded, gocheck.Equals, int64(0))
+}
+
+func (s *S) TestUploadProgressCountsSentBytes(c *gocheck.C) {
+	var buf bytes.Buffer
+	p := newUploadProgress(&bytes.Buffer{}, 100)
+	w := p.sent(&buf)
+	n, err := w.Write([]byte("compressed"))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 10)
+	c.Assert(buf.String(), gocheck.Equals, "compressed")
+	c.Assert(p.uploaded, gocheck.Equals, int64(10))
+	c.Assert(p.archived, gocheck.Equals, int64(0))
+}
+
+func (s *S) TestUploadProgressLine(c *gocheck.C) {
+	p := newUploadProgress(&bytes.Buffer{}, 10<<20)
+	c.Assert(p.tty, gocheck.Equals, false)
+	line := p.line(5<<20, 1<<20, 10*time.Second)
+	c.Assert(line, gocheck.Equals, "Uploading files: 50% of 10.0 MB (1.0 MB sent) 102.4 KB/s ETA 0:10")
+	p.tty = true
+	line = p.line(5<<20, 1<<20, 10*time.Second)
+	c.Assert(line, gocheck.Equals, "Uploading files [==========>         ]  50% of 10.0 MB, 1.0 MB sent 102.4 KB/s ETA 0:10")
+	line = p.line(0, 0, 0)
+	c.Assert(line, gocheck.Equals, "Uploading files [>                   ]   0% of 10.0 MB, 0 B sent 0 B/s ETA --:--")
+	line = p.line(10<<20, 4<<20, time.Second)
+	c.Assert(line, gocheck.Equals, "Uploading files [====================] 100% of 10.0 MB, 4.0 MB sent 4.0 MB/s ETA 0:00")
+}
+
+func (s *S) TestUploadProgressEmptyArchive(c *gocheck.C) {
+	p := newUploadProgress(&bytes.Buffer{}, 0)
+	c.Assert(p.percent(0), gocheck.Equals, int64(100))
+}
+
+func (s *S) TestUploadProgressStop(c *gocheck.C) {
+	var buf bytes.Buffer
+	p := newUploadProgress(&buf, 2048)
+	p.start()
+	p.Write(make([]byte, 2048))
+	p.sent(&bytes.Buffer{}).Write(make([]byte, 512))
+	p.stop()
+	c.Assert(buf.String(), gocheck.Matches, `Uploading files: 100% of 2\.0 KB \(512 B sent\) .+/s in 0:00\.\.\.`)
+}
+
+func (s *S) TestFormatDuration(c *gocheck.C) {
+	c.Assert(formatDuration(0), gocheck.Equals, "0:00")
+	c.Assert(formatDuration(75*time.Second), gocheck.Equals, "1:15")
+	c.Assert(formatDuration(2*time.Hour+3*time.Minute+4*time.Second), gocheck.Equals, "2:03:04")
+}
+
+func (s *S) TestFormatSize(c *gocheck.C) {
+	c.Assert(formatSize(512), gocheck.Equals, "512 B")
+	c.Assert(formatSize(1536), gocheck.Equals, "1.5 KB")
+	c.Assert(formatSize(3<<30), gocheck.Equals, "3.0 GB")
+}
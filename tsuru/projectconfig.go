@@ -0,0 +1,109 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/exec"
+	"gopkg.in/yaml.v1"
+)
+
+const projectConfigFileName = ".tsuru.yaml"
+
+// projectConfig is the configuration of the project in the current
+// directory, kept in its .tsuru.yaml file. Unlike tsuru.yaml, which is sent
+// to the server with the other files of the app, this file is only used by
+// the client.
+type projectConfig struct {
+	// Target is the tsuru server of the project, given by its label in
+	// target-list or by its address.
+	Target string      `yaml:"target"`
+	App    string      `yaml:"app"`
+	Hooks  deployHooks `yaml:"hooks"`
+}
+
+// deployHooks are the commands run by app-deploy before the upload and after
+// the deploy finishes.
+type deployHooks struct {
+	PreDeploy  []string `yaml:"pre-deploy"`
+	PostDeploy []string `yaml:"post-deploy"`
+}
+
+// findProjectConfig loads the .tsuru.yaml file of the project that contains
+// the given directory, looking for it in the directory and its parents. It
+// returns the path of the file, which is empty when there's no file.
+func findProjectConfig(dir string) (*projectConfig, string, error) {
+	for {
+		path := filepath.Join(dir, projectConfigFileName)
+		config, found, err := loadProjectConfig(path)
+		if found || err != nil {
+			return config, path, err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return config, "", nil
+		}
+		dir = parent
+	}
+}
+
+func loadProjectConfig(path string) (*projectConfig, bool, error) {
+	var config projectConfig
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &config, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid %s: %s", path, err)
+	}
+	return &config, true, nil
+}
+
+// projectGuesser guesses the name of the app from the TSURU_APP environment
+// variable or from the .tsuru.yaml file of the project, falling back to the
+// tsuru remote of its git repository.
+type projectGuesser struct{}
+
+func (projectGuesser) GuessName(path string) (string, error) {
+	if app := os.Getenv("TSURU_APP"); app != "" {
+		return app, nil
+	}
+	config, _, err := findProjectConfig(path)
+	if err != nil {
+		return "", err
+	}
+	if config.App != "" {
+		return config.App, nil
+	}
+	return cmd.GitGuesser{}.GuessName(path)
+}
+
+// runHooks runs the given commands with the shell in dir, which is the root
+// of the project, in order, stopping at the first one that fails.
+func runHooks(context *cmd.Context, kind, dir string, commands []string, envs []string) error {
+	for _, command := range commands {
+		fmt.Fprintf(context.Stdout, "Running %s hook: %s\n", kind, command)
+		opts := exec.ExecuteOptions{
+			Cmd:    "sh",
+			Args:   []string{"-c", command},
+			Envs:   envs,
+			Dir:    dir,
+			Stdout: context.Stdout,
+			Stderr: context.Stderr,
+		}
+		if err := executor().Execute(opts); err != nil {
+			return fmt.Errorf("%s hook %q failed: %s", kind, command, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/exec"
+	"github.com/tsuru/tsuru/exec/exectest"
+	"launchpad.net/gocheck"
+)
+
+func (s *S) TestFindProjectConfigHooks(c *gocheck.C) {
+	dir := c.MkDir()
+	content := `hooks:
+  pre-deploy:
+    - make test
+    - make assets
+  post-deploy:
+    - ./notify.sh
+`
+	err := ioutil.WriteFile(filepath.Join(dir, projectConfigFileName), []byte(content), 0644)
+	c.Assert(err, gocheck.IsNil)
+	config, _, err := findProjectConfig(dir)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(config.Hooks.PreDeploy, gocheck.DeepEquals, []string{"make test", "make assets"})
+	c.Assert(config.Hooks.PostDeploy, gocheck.DeepEquals, []string{"./notify.sh"})
+}
+
+func (s *S) TestFindProjectConfigInvalid(c *gocheck.C) {
+	dir := c.MkDir()
+	err := ioutil.WriteFile(filepath.Join(dir, projectConfigFileName), []byte("hooks: [\n"), 0644)
+	c.Assert(err, gocheck.IsNil)
+	_, _, err = findProjectConfig(dir)
+	c.Assert(err, gocheck.ErrorMatches, `invalid .*/\.tsuru\.yaml: .*`)
+}
+
+func (s *S) TestRunHooks(c *gocheck.C) {
+	fexec := exectest.FakeExecutor{}
+	execut = &fexec
+	defer func() { execut = nil }()
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	envs := []string{"TSURU_APP_NAME=myapp"}
+	err := runHooks(&context, "pre-deploy", "/home/me/myapp", []string{"make test", "make assets"}, envs)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(fexec.ExecutedCmd("sh", []string{"-c", "make test"}), gocheck.Equals, true)
+	c.Assert(fexec.ExecutedCmd("sh", []string{"-c", "make assets"}), gocheck.Equals, true)
+	cmds := fexec.GetCommands("sh")
+	c.Assert(cmds, gocheck.HasLen, 2)
+	c.Assert(cmds[0].GetEnvs(), gocheck.DeepEquals, envs)
+	c.Assert(cmds[0].GetDir(), gocheck.Equals, "/home/me/myapp")
+	c.Assert(stdout.String(), gocheck.Equals, "Running pre-deploy hook: make test\nRunning pre-deploy hook: make assets\n")
+}
+
+type failingExecutor struct {
+	exectest.FakeExecutor
+	err error
+}
+
+func (e *failingExecutor) Execute(opts exec.ExecuteOptions) error {
+	e.FakeExecutor.Execute(opts)
+	return e.err
+}
+
+func (s *S) TestRunHooksFailure(c *gocheck.C) {
+	fexec := failingExecutor{err: errors.New("exit status 2")}
+	execut = &fexec
+	defer func() { execut = nil }()
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	err := runHooks(&context, "pre-deploy", "/home/me/myapp", []string{"make test", "make assets"}, nil)
+	c.Assert(err, gocheck.ErrorMatches, `pre-deploy hook "make test" failed: exit status 2`)
+	c.Assert(fexec.GetCommands("sh"), gocheck.HasLen, 1)
+}
+
+func (s *S) TestFindProjectConfigInParentDirectory(c *gocheck.C) {
+	root := c.MkDir()
+	content := "target: staging\napp: myapp\n"
+	err := ioutil.WriteFile(filepath.Join(root, projectConfigFileName), []byte(content), 0644)
+	c.Assert(err, gocheck.IsNil)
+	dir := filepath.Join(root, "src", "pkg")
+	err = os.MkdirAll(dir, 0755)
+	c.Assert(err, gocheck.IsNil)
+	config, path, err := findProjectConfig(dir)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(path, gocheck.Equals, filepath.Join(root, projectConfigFileName))
+	c.Assert(config.Target, gocheck.Equals, "staging")
+	c.Assert(config.App, gocheck.Equals, "myapp")
+}
+
+func (s *S) TestFindProjectConfigMissingFile(c *gocheck.C) {
+	config, path, err := findProjectConfig(c.MkDir())
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(path, gocheck.Equals, "")
+	c.Assert(config, gocheck.DeepEquals, &projectConfig{})
+}
+
+func (s *S) TestProjectGuesser(c *gocheck.C) {
+	dir := c.MkDir()
+	err := ioutil.WriteFile(filepath.Join(dir, projectConfigFileName), []byte("app: myapp\n"), 0644)
+	c.Assert(err, gocheck.IsNil)
+	name, err := projectGuesser{}.GuessName(dir)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(name, gocheck.Equals, "myapp")
+}
+
+func (s *S) TestProjectGuesserEnvironmentVariable(c *gocheck.C) {
+	os.Setenv("TSURU_APP", "otherapp")
+	defer os.Unsetenv("TSURU_APP")
+	dir := c.MkDir()
+	err := ioutil.WriteFile(filepath.Join(dir, projectConfigFileName), []byte("app: myapp\n"), 0644)
+	c.Assert(err, gocheck.IsNil)
+	name, err := projectGuesser{}.GuessName(dir)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(name, gocheck.Equals, "otherapp")
+}
+
+func (s *S) TestProjectGuesserFallsBackToGit(c *gocheck.C) {
+	dir := c.MkDir()
+	gitDir := filepath.Join(dir, ".git")
+	err := os.Mkdir(gitDir, 0755)
+	c.Assert(err, gocheck.IsNil)
+	config := `[remote "tsuru"]
+	url = git@tsuru.example.com:gitapp.git
+`
+	err = ioutil.WriteFile(filepath.Join(gitDir, "config"), []byte(config), 0644)
+	c.Assert(err, gocheck.IsNil)
+	name, err := projectGuesser{}.GuessName(dir)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(name, gocheck.Equals, "gitapp")
+}
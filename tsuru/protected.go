@@ -0,0 +1,181 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/tsuru/tsuru/cmd"
+	"gopkg.in/yaml.v1"
+	"launchpad.net/gnuflag"
+)
+
+// clientConfig is the configuration of the client, kept in
+// ~/.tsuru/config.yaml.
+type clientConfig struct {
+	Protected protectedResources `yaml:"protected"`
+}
+
+// protectedResources are the targets and apps on which destructive commands
+// require an explicit confirmation. Targets are given by their labels or
+// addresses, and all apps of a protected target are protected.
+type protectedResources struct {
+	Targets []string `yaml:"targets"`
+	Apps    []string `yaml:"apps"`
+}
+
+func clientConfigPath() string {
+	return cmd.JoinWithUserDir(".tsuru", "config.yaml")
+}
+
+// loadClientConfig reads the configuration of the client. A missing file is
+// an empty configuration.
+func loadClientConfig() (*clientConfig, error) {
+	var config clientConfig
+	f, err := filesystem().Open(clientConfigPath())
+	if os.IsNotExist(err) {
+		return &config, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", clientConfigPath(), err)
+	}
+	return &config, nil
+}
+
+// protectedApps returns the given apps that are protected in the current
+// target.
+func (p *protectedResources) protectedApps(appNames ...string) ([]string, error) {
+	if len(p.Targets) > 0 {
+		current, err := cmd.GetURL("")
+		if err != nil {
+			return nil, err
+		}
+		for _, target := range p.Targets {
+			address, err := resolveTarget(target)
+			if err != nil {
+				return nil, err
+			}
+			if normalizeTarget(address) == current {
+				return appNames, nil
+			}
+		}
+	}
+	var protected []string
+	for _, name := range appNames {
+		for _, app := range p.Apps {
+			if app == name {
+				protected = append(protected, name)
+				break
+			}
+		}
+	}
+	return protected, nil
+}
+
+// normalizeTarget formats the address of a target like cmd.GetURL does.
+func normalizeTarget(target string) string {
+	if m, _ := regexp.MatchString("^https?://", target); !m {
+		target = "http://" + target
+	}
+	return strings.TrimRight(target, "/")
+}
+
+// protectedConfirmation extends cmd.ConfirmationCommand for destructive
+// commands. On protected apps and targets, a y/n answer isn't enough, and
+// neither is -y/--assume-yes: the user must type the name of the app, or give
+// --i-know-this-is-prod when running without a terminal.
+type protectedConfirmation struct {
+	cmd.ConfirmationCommand
+	protectedCheck
+	fs *gnuflag.FlagSet
+}
+
+func (c *protectedConfirmation) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = cmd.MergeFlagSet(
+			c.ConfirmationCommand.Flags(),
+			c.protectedCheck.Flags(),
+		)
+	}
+	return c.fs
+}
+
+// confirm asks the question with cmd.ConfirmationCommand, unless some of the
+// apps are protected, in which case their names must be typed instead.
+func (c *protectedConfirmation) confirm(context *cmd.Context, question string, appNames ...string) (bool, error) {
+	protected, err := c.protectedApps(appNames...)
+	if err != nil {
+		return false, err
+	}
+	if len(protected) == 0 {
+		return c.Confirm(context, question), nil
+	}
+	return c.confirmNames(context, protected)
+}
+
+// protectedCheck is used by destructive commands that don't ask for
+// confirmation: only the names of the apps that are protected are asked, so
+// the only flag it adds is --i-know-this-is-prod.
+type protectedCheck struct {
+	fs    *gnuflag.FlagSet
+	iKnow bool
+}
+
+func (c *protectedCheck) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("", gnuflag.ExitOnError)
+		c.fs.BoolVar(&c.iKnow, "i-know-this-is-prod", false, "Don't ask for the name of protected apps")
+	}
+	return c.fs
+}
+
+// confirmProtected asks for the names of the apps that are protected.
+func (c *protectedCheck) confirmProtected(context *cmd.Context, appNames ...string) (bool, error) {
+	protected, err := c.protectedApps(appNames...)
+	if err != nil {
+		return false, err
+	}
+	return c.confirmNames(context, protected)
+}
+
+func (c *protectedCheck) protectedApps(appNames ...string) ([]string, error) {
+	config, err := loadClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.Protected.protectedApps(appNames...)
+}
+
+func (c *protectedCheck) confirmNames(context *cmd.Context, appNames []string) (bool, error) {
+	if c.iKnow {
+		return true, nil
+	}
+	for _, name := range appNames {
+		fmt.Fprintf(context.Stdout, "App %q is protected. Type its name to confirm: ", name)
+		var answer string
+		if n, err := fmt.Fscanf(context.Stdin, "%s\n", &answer); n != 1 || err != nil {
+			fmt.Fprintln(context.Stdout)
+			return false, errors.New("Confirmation required for a protected app. Type the name of the app, or use --i-know-this-is-prod.")
+		}
+		if answer != name {
+			fmt.Fprintln(context.Stdout, "Abort.")
+			return false, nil
+		}
+	}
+	return true, nil
+}
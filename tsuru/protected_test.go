@@ -0,0 +1,158 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/fs/fstest"
+	"launchpad.net/gnuflag"
+	"launchpad.net/gocheck"
+)
+
+func (s *S) TestLoadClientConfig(c *gocheck.C) {
+	rfs := &fstest.RecordingFs{FileContent: "protected:\n  targets:\n    - prod\n  apps:\n    - myapp\n"}
+	fsystem = rfs
+	defer func() { fsystem = nil }()
+	config, err := loadClientConfig()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(config.Protected.Targets, gocheck.DeepEquals, []string{"prod"})
+	c.Assert(config.Protected.Apps, gocheck.DeepEquals, []string{"myapp"})
+	c.Assert(rfs.HasAction("open "+clientConfigPath()), gocheck.Equals, true)
+}
+
+func (s *S) TestLoadClientConfigNotFound(c *gocheck.C) {
+	fsystem = &fstest.FileNotFoundFs{}
+	defer func() { fsystem = nil }()
+	config, err := loadClientConfig()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(config.Protected.Apps, gocheck.HasLen, 0)
+}
+
+func (s *S) TestLoadClientConfigInvalid(c *gocheck.C) {
+	fsystem = &fstest.RecordingFs{FileContent: "protected: ["}
+	defer func() { fsystem = nil }()
+	_, err := loadClientConfig()
+	c.Assert(err, gocheck.ErrorMatches, "invalid .*config.yaml: .*")
+}
+
+func (s *S) TestProtectedApps(c *gocheck.C) {
+	p := protectedResources{Apps: []string{"myapp", "otherapp"}}
+	protected, err := p.protectedApps("myapp", "someapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(protected, gocheck.DeepEquals, []string{"myapp"})
+}
+
+func (s *S) TestProtectedAppsInProtectedTarget(c *gocheck.C) {
+	fsystem = &fstest.FileNotFoundFs{}
+	defer func() { fsystem = nil }()
+	p := protectedResources{Targets: []string{"localhost:8080/"}}
+	protected, err := p.protectedApps("myapp", "someapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(protected, gocheck.DeepEquals, []string{"myapp", "someapp"})
+}
+
+func (s *S) TestProtectedAppsTargetLabel(c *gocheck.C) {
+	fsystem = &fstest.RecordingFs{FileContent: "prod\thttp://localhost:8080\n"}
+	defer func() { fsystem = nil }()
+	p := protectedResources{Targets: []string{"prod"}}
+	protected, err := p.protectedApps("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(protected, gocheck.DeepEquals, []string{"myapp"})
+}
+
+func (s *S) TestProtectedAppsInOtherTarget(c *gocheck.C) {
+	fsystem = &fstest.FileNotFoundFs{}
+	defer func() { fsystem = nil }()
+	p := protectedResources{Targets: []string{"https://tsuru.example.com"}}
+	protected, err := p.protectedApps("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(protected, gocheck.HasLen, 0)
+}
+
+func (s *S) TestConfirmNames(c *gocheck.C) {
+	var stdout bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stdin: strings.NewReader("myapp\notherapp\n")}
+	var command protectedConfirmation
+	ok, err := command.confirmNames(&context, []string{"myapp", "otherapp"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(ok, gocheck.Equals, true)
+	expected := `App "myapp" is protected. Type its name to confirm: ` +
+		`App "otherapp" is protected. Type its name to confirm: `
+	c.Assert(stdout.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestConfirmNamesWrongName(c *gocheck.C) {
+	var stdout bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stdin: strings.NewReader("y\n")}
+	var command protectedConfirmation
+	ok, err := command.confirmNames(&context, []string{"myapp"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(ok, gocheck.Equals, false)
+	c.Assert(stdout.String(), gocheck.Equals, `App "myapp" is protected. Type its name to confirm: Abort.`+"\n")
+}
+
+func (s *S) TestConfirmNamesWithoutTerminal(c *gocheck.C) {
+	var stdout bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stdin: strings.NewReader("")}
+	var command protectedConfirmation
+	command.Flags().Parse(true, []string{"-y"})
+	ok, err := command.confirmNames(&context, []string{"myapp"})
+	c.Assert(err, gocheck.ErrorMatches, "Confirmation required for a protected app. .*--i-know-this-is-prod.")
+	c.Assert(ok, gocheck.Equals, false)
+}
+
+func (s *S) TestConfirmNamesIKnowThisIsProd(c *gocheck.C) {
+	var stdout bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stdin: strings.NewReader("")}
+	var command protectedConfirmation
+	command.Flags().Parse(true, []string{"--i-know-this-is-prod"})
+	ok, err := command.confirmNames(&context, []string{"myapp"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(stdout.String(), gocheck.Equals, "")
+}
+
+func (s *S) TestConfirmUnprotectedApp(c *gocheck.C) {
+	fsystem = &fstest.FileNotFoundFs{}
+	defer func() { fsystem = nil }()
+	var stdout bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stdin: strings.NewReader("y\n")}
+	var command protectedConfirmation
+	ok, err := command.confirm(&context, "Are you sure?", "myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(stdout.String(), gocheck.Equals, "Are you sure? (y/n) ")
+}
+
+func (s *S) TestProtectedCheckFlags(c *gocheck.C) {
+	var command protectedCheck
+	flagset := command.Flags()
+	c.Assert(flagset.Lookup("i-know-this-is-prod"), gocheck.NotNil)
+	c.Assert(flagset.Lookup("y"), gocheck.IsNil)
+	c.Assert(flagset.Lookup("assume-yes"), gocheck.IsNil)
+}
+
+func (s *S) TestCommandsWithoutConfirmationDontTakeAssumeYes(c *gocheck.C) {
+	commands := []interface {
+		Flags() *gnuflag.FlagSet
+	}{&unitRemove{}, &envUnset{}, &serviceUnbind{}, &appSwap{}}
+	for _, command := range commands {
+		flagset := command.Flags()
+		c.Check(flagset.Lookup("i-know-this-is-prod"), gocheck.NotNil)
+		c.Check(flagset.Lookup("y"), gocheck.IsNil)
+		c.Check(flagset.Lookup("assume-yes"), gocheck.IsNil)
+	}
+}
+
+func (s *S) TestProtectedConfirmationFlags(c *gocheck.C) {
+	var command protectedConfirmation
+	flagset := command.Flags()
+	c.Assert(flagset.Lookup("i-know-this-is-prod"), gocheck.NotNil)
+	c.Assert(flagset.Lookup("y"), gocheck.NotNil)
+	c.Assert(flagset.Lookup("assume-yes"), gocheck.NotNil)
+}
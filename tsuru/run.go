@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"strings"
 
+	tsuruClient "github.com/tsuru/tsuru-client/tsuru/client"
 	"github.com/tsuru/tsuru/cmd"
 	tsuruIo "github.com/tsuru/tsuru/io"
 	"launchpad.net/gnuflag"
@@ -21,6 +22,8 @@ type appRun struct {
 	once bool
 }
 
+func (*appRun) mutatesTarget() {}
+
 func (c *appRun) Info() *cmd.Info {
 	desc := `run a command in all instances of the app, and prints the output.
 
@@ -41,7 +44,7 @@ func (c *appRun) Run(context *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return err
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/run?once=%t", appName, c.once))
+	url, err := cmd.GetURL(tsuruClient.Path("/apps/%s/run?once=%t", appName, c.once))
 	if err != nil {
 		return err
 	}
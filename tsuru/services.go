@@ -15,17 +15,20 @@ import (
 	"sort"
 	"strings"
 
+	tsuruClient "github.com/tsuru/tsuru-client/tsuru/client"
 	"github.com/tsuru/tsuru/cmd"
 	tsuruIo "github.com/tsuru/tsuru/io"
 	"launchpad.net/gnuflag"
 )
 
-type serviceList struct{}
+type serviceList struct {
+	outputCommand
+}
 
 func (s serviceList) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "service-list",
-		Usage: "service-list",
+		Usage: "service-list [--json | --yaml | --format <template> | -q/--quiet]",
 		Desc:  "Get all available services, and user's instances for this services",
 	}
 }
@@ -48,6 +51,18 @@ func (s serviceList) Run(ctx *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return err
 	}
+	if s.structured() {
+		services := []serviceData{}
+		err = json.Unmarshal(b, &services)
+		if err != nil {
+			return err
+		}
+		var names []string
+		for _, service := range services {
+			names = append(names, service.Instances...)
+		}
+		return s.write(ctx.Stdout, services, names)
+	}
 	rslt, err := cmd.ShowServicesInstancesList(b)
 	if err != nil {
 		return err
@@ -64,6 +79,8 @@ type serviceAdd struct {
 	teamOwner string
 }
 
+func (*serviceAdd) mutatesTarget() {}
+
 func (c *serviceAdd) Info() *cmd.Info {
 	usage := `service-add <servicename> <serviceinstancename> [plan] [-t/--owner-team <team>]
 e.g.:
@@ -128,36 +145,23 @@ type serviceBind struct {
 	cmd.GuessingCommand
 }
 
+func (*serviceBind) mutatesTarget() {}
+
 func (sb *serviceBind) Run(ctx *cmd.Context, client *cmd.Client) error {
 	appName, err := sb.Guess()
 	if err != nil {
 		return err
 	}
-	instanceName := ctx.Args[0]
-	url, err := cmd.GetURL("/services/instances/" + instanceName + "/" + appName)
-	if err != nil {
-		return err
-	}
-	request, err := http.NewRequest("PUT", url, nil)
-	if err != nil {
-		return err
-	}
-	resp, err := client.Do(request)
+	api, err := apiClient(client)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	w := tsuruIo.NewStreamWriter(ctx.Stdout, nil)
-	for n := int64(1); n > 0 && err == nil; n, err = io.Copy(w, resp.Body) {
-	}
+	stream, err := api.BindService(ctx.Args[0], appName)
 	if err != nil {
 		return err
 	}
-	unparsed := w.Remaining()
-	if len(unparsed) > 0 {
-		return fmt.Errorf("unparsed message error: %s", string(unparsed))
-	}
-	return nil
+	defer stream.Close()
+	return streamOutput(ctx.Stdout, stream, nil)
 }
 
 func (sb *serviceBind) Info() *cmd.Info {
@@ -173,6 +177,20 @@ If you don't provide the app name, tsuru will try to guess it.`,
 
 type serviceUnbind struct {
 	cmd.GuessingCommand
+	protectedCheck
+	fs *gnuflag.FlagSet
+}
+
+func (*serviceUnbind) mutatesTarget() {}
+
+func (su *serviceUnbind) Flags() *gnuflag.FlagSet {
+	if su.fs == nil {
+		su.fs = cmd.MergeFlagSet(
+			su.GuessingCommand.Flags(),
+			su.protectedCheck.Flags(),
+		)
+	}
+	return su.fs
 }
 
 func (su *serviceUnbind) Run(ctx *cmd.Context, client *cmd.Client) error {
@@ -180,8 +198,11 @@ func (su *serviceUnbind) Run(ctx *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return err
 	}
+	if ok, err := su.confirmProtected(ctx, appName); !ok || err != nil {
+		return err
+	}
 	instanceName := ctx.Args[0]
-	url, err := cmd.GetURL("/services/instances/" + instanceName + "/" + appName)
+	url, err := cmd.GetURL(tsuruClient.Path("/services/instances/%s/%s", instanceName, appName))
 	if err != nil {
 		return err
 	}
@@ -210,7 +231,7 @@ func (su *serviceUnbind) Run(ctx *cmd.Context, client *cmd.Client) error {
 func (su *serviceUnbind) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "service-unbind",
-		Usage: "service-unbind <instancename> [-a/--app appname]",
+		Usage: "service-unbind <instancename> [-a/--app appname] [--i-know-this-is-prod]",
 		Desc: `unbind a service instance from an app
 
 If you don't provide the app name, tsuru will try to guess it.`,
@@ -236,7 +257,7 @@ e.g.:
 
 func (c serviceInstanceStatus) Run(ctx *cmd.Context, client *cmd.Client) error {
 	instName := ctx.Args[0]
-	url, err := cmd.GetURL("/services/instances/" + instName + "/status")
+	url, err := cmd.GetURL(tsuruClient.Path("/services/instances/%s/status", instName))
 	if err != nil {
 		return err
 	}
@@ -264,10 +285,12 @@ func (c serviceInstanceStatus) Run(ctx *cmd.Context, client *cmd.Client) error {
 	return nil
 }
 
-type serviceInfo struct{}
+type serviceInfo struct {
+	outputCommand
+}
 
 func (c serviceInfo) Info() *cmd.Info {
-	usg := `service-info <service>
+	usg := `service-info <service> [--json | --yaml | --format <template> | -q/--quiet]
 e.g.:
 
     $ tsuru service-info mongodb
@@ -310,25 +333,7 @@ func (serviceInfo) ExtraHeaders(instances []ServiceInstanceModel) []string {
 }
 
 func (c serviceInfo) BuildInstancesTable(serviceName string, ctx *cmd.Context, client *cmd.Client) error {
-	url, err := cmd.GetURL("/services/" + serviceName)
-	if err != nil {
-		return err
-	}
-	request, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return err
-	}
-	resp, err := client.Do(request)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	result, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-	var instances []ServiceInstanceModel
-	err = json.Unmarshal(result, &instances)
+	instances, err := c.instances(serviceName, client)
 	if err != nil {
 		return err
 	}
@@ -355,42 +360,110 @@ func (c serviceInfo) BuildInstancesTable(serviceName string, ctx *cmd.Context, c
 
 func (c serviceInfo) BuildPlansTable(serviceName string, ctx *cmd.Context, client *cmd.Client) error {
 	ctx.Stdout.Write([]byte("\nPlans\n"))
-	url, err := cmd.GetURL(fmt.Sprintf("/services/%s/plans", serviceName))
+	plans, err := c.plans(serviceName, client)
 	if err != nil {
 		return err
 	}
+	if len(plans) > 0 {
+		table := cmd.NewTable()
+		for _, plan := range plans {
+			data := []string{plan["Name"], plan["Description"]}
+			table.AddRow(cmd.Row(data))
+		}
+		table.Headers = cmd.Row([]string{"Name", "Description"})
+		ctx.Stdout.Write(table.Bytes())
+	}
+	return nil
+}
+
+func (serviceInfo) instances(serviceName string, client *cmd.Client) ([]ServiceInstanceModel, error) {
+	url, err := cmd.GetURL(tsuruClient.Path("/services/%s", serviceName))
+	if err != nil {
+		return nil, err
+	}
 	request, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	resp, err := client.Do(request)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 	result, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	var instances []ServiceInstanceModel
+	err = json.Unmarshal(result, &instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+func (serviceInfo) plans(serviceName string, client *cmd.Client) ([]map[string]string, error) {
+	url, err := cmd.GetURL(tsuruClient.Path("/services/%s/plans", serviceName))
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	result, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
 	}
 	var plans []map[string]string
 	err = json.Unmarshal(result, &plans)
 	if err != nil {
-		return err
-	}
-	if len(plans) > 0 {
-		table := cmd.NewTable()
-		for _, plan := range plans {
-			data := []string{plan["Name"], plan["Description"]}
-			table.AddRow(cmd.Row(data))
-		}
-		table.Headers = cmd.Row([]string{"Name", "Description"})
-		ctx.Stdout.Write(table.Bytes())
+		return nil, err
 	}
-	return nil
+	return plans, nil
+}
+
+// serviceInfoOutput is the document printed by service-info --json and
+// --yaml.
+type serviceInfoOutput struct {
+	Service   string
+	Instances []ServiceInstanceModel
+	Plans     []map[string]string
 }
 
 func (c serviceInfo) Run(ctx *cmd.Context, client *cmd.Client) error {
 	serviceName := ctx.Args[0]
+	if c.structured() {
+		instances, err := c.instances(serviceName, client)
+		if err != nil {
+			return err
+		}
+		plans, err := c.plans(serviceName, client)
+		if err != nil {
+			return err
+		}
+		output := serviceInfoOutput{
+			Service:   serviceName,
+			Instances: instances,
+			Plans:     plans,
+		}
+		if output.Instances == nil {
+			output.Instances = []ServiceInstanceModel{}
+		}
+		if output.Plans == nil {
+			output.Plans = []map[string]string{}
+		}
+		names := make([]string, len(instances))
+		for i, instance := range instances {
+			names[i] = instance.Name
+		}
+		return c.write(ctx.Stdout, output, names)
+	}
 	err := c.BuildInstancesTable(serviceName, ctx, client)
 	if err != nil {
 		return err
@@ -411,8 +484,7 @@ func (serviceDoc) Info() *cmd.Info {
 
 func (serviceDoc) Run(ctx *cmd.Context, client *cmd.Client) error {
 	sName := ctx.Args[0]
-	url := fmt.Sprintf("/services/%s/doc", sName)
-	url, err := cmd.GetURL(url)
+	url, err := cmd.GetURL(tsuruClient.Path("/services/%s/doc", sName))
 	if err != nil {
 		return err
 	}
@@ -438,6 +510,8 @@ type serviceRemove struct {
 	fs  *gnuflag.FlagSet
 }
 
+func (*serviceRemove) mutatesTarget() {}
+
 func (c *serviceRemove) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "service-remove",
@@ -458,8 +532,7 @@ func (c *serviceRemove) Run(ctx *cmd.Context, client *cmd.Client) error {
 			return nil
 		}
 	}
-	url := fmt.Sprintf("/services/instances/%s", name)
-	url, err := cmd.GetURL(url)
+	url, err := cmd.GetURL(tsuruClient.Path("/services/instances/%s", name))
 	if err != nil {
 		return err
 	}
@@ -85,7 +85,7 @@ func (s *S) TestServiceListWithEmptyResponse(c *gocheck.C) {
 func (s *S) TestInfoServiceList(c *gocheck.C) {
 	expected := &cmd.Info{
 		Name:    "service-list",
-		Usage:   "service-list",
+		Usage:   "service-list [--json | --yaml | --format <template> | -q/--quiet]",
 		Desc:    "Get all available services, and user's instances for this services",
 		MinArgs: 0,
 	}
@@ -245,6 +245,24 @@ func (s *S) TestServiceUnbind(c *gocheck.C) {
 	c.Assert(stdout.String(), gocheck.Equals, expectedOut)
 }
 
+func (s *S) TestServiceUnbindEscapesNames(c *gocheck.C) {
+	var called bool
+	ctx := cmd.Context{Args: []string{"my db/prod"}, Stdout: &bytes.Buffer{}, Stderr: &bytes.Buffer{}}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: `{"Message":"unbound"}`, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			return req.Method == "DELETE" && req.URL.EscapedPath() == "/services/instances/my%20db%2Fprod/app%2B1"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := serviceUnbind{}
+	command.Flags().Parse(true, []string{"-a", "app+1"})
+	err := command.Run(&ctx, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+}
+
 func (s *S) TestServiceUnbindWithoutFlag(c *gocheck.C) {
 	var stdout, stderr bytes.Buffer
 	var called bool
@@ -266,7 +284,7 @@ func (s *S) TestServiceUnbindWithoutFlag(c *gocheck.C) {
 	}
 	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
 	fake := &cmdtest.FakeGuesser{Name: "sleeve"}
-	err = (&serviceUnbind{cmd.GuessingCommand{G: fake}}).Run(&ctx, client)
+	err = (&serviceUnbind{GuessingCommand: cmd.GuessingCommand{G: fake}}).Run(&ctx, client)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(called, gocheck.Equals, true)
 	c.Assert(stdout.String(), gocheck.Equals, expectedOut)
@@ -292,7 +310,7 @@ func (s *S) TestServiceUnbindWithRequestFailure(c *gocheck.C) {
 func (s *S) TestServiceUnbindInfo(c *gocheck.C) {
 	expected := &cmd.Info{
 		Name:  "service-unbind",
-		Usage: "service-unbind <instancename> [-a/--app appname]",
+		Usage: "service-unbind <instancename> [-a/--app appname] [--i-know-this-is-prod]",
 		Desc: `unbind a service instance from an app
 
 If you don't provide the app name, tsuru will try to guess it.`,
@@ -398,7 +416,7 @@ func (s *S) TestServiceInstanceStatusRun(c *gocheck.C) {
 }
 
 func (s *S) TestServiceInfoInfo(c *gocheck.C) {
-	usg := `service-info <service>
+	usg := `service-info <service> [--json | --yaml | --format <template> | -q/--quiet]
 e.g.:
 
     $ tsuru service-info mongodb
@@ -565,3 +583,53 @@ func (s *S) TestServiceRemoveFlags(c *gocheck.C) {
 	c.Check(sassume.DefValue, gocheck.Equals, "false")
 	c.Check(command.yes, gocheck.Equals, true)
 }
+
+func (s *S) TestServiceInfoJSON(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	instances := `[{"Name":"mymongo", "Apps":["myapp"], "Info":{"key": "value"}}]`
+	plans := `[{"Name":"small","Description":"another plan"}]`
+	trans := &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: instances, Status: http.StatusOK},
+				CondFunc:  func(req *http.Request) bool { return req.URL.Path == "/services/mongodb" },
+			},
+			{
+				Transport: cmdtest.Transport{Message: plans, Status: http.StatusOK},
+				CondFunc:  func(req *http.Request) bool { return req.URL.Path == "/services/mongodb/plans" },
+			},
+		},
+	}
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr, Args: []string{"mongodb"}}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := serviceInfo{}
+	err := command.Flags().Parse(true, []string{"--json"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	var output serviceInfoOutput
+	err = json.Unmarshal(stdout.Bytes(), &output)
+	c.Assert(err, gocheck.IsNil)
+	expected := serviceInfoOutput{
+		Service:   "mongodb",
+		Instances: []ServiceInstanceModel{{Name: "mymongo", Apps: []string{"myapp"}, Info: map[string]string{"key": "value"}}},
+		Plans:     []map[string]string{{"Name": "small", "Description": "another plan"}},
+	}
+	c.Assert(output, gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestServiceListJSON(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	output := `[{"service": "mysql", "instances": ["mysql01", "mysql02"]}]`
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: &cmdtest.Transport{Message: output, Status: http.StatusOK}}, nil, manager)
+	command := serviceList{}
+	err := command.Flags().Parse(true, []string{"--json"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	var services []serviceData
+	err = json.Unmarshal(stdout.Bytes(), &services)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(services, gocheck.DeepEquals, []serviceData{{Service: "mysql", Instances: []string{"mysql01", "mysql02"}}})
+}
@@ -37,4 +37,13 @@ func Test(t *testing.T) { gocheck.TestingT(t) }
 func (s *S) SetUpTest(c *gocheck.C) {
 	var stdout, stderr bytes.Buffer
 	manager = cmd.NewManager("glb", version, header, &stdout, &stderr, os.Stdin, nil)
+	exitr = &recordingExiter{}
+}
+
+type recordingExiter struct {
+	codes []int
+}
+
+func (e *recordingExiter) Exit(code int) {
+	e.codes = append(e.codes, code)
 }
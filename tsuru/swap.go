@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"strings"
 
+	tsuruClient "github.com/tsuru/tsuru-client/tsuru/client"
 	"github.com/tsuru/tsuru/cmd"
 	"github.com/tsuru/tsuru/errors"
 	"launchpad.net/gnuflag"
@@ -16,14 +17,17 @@ import (
 
 type appSwap struct {
 	cmd.Command
+	protectedCheck
 	force bool
 	fs    *gnuflag.FlagSet
 }
 
+func (*appSwap) mutatesTarget() {}
+
 func (s *appSwap) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "app-swap",
-		Usage:   "app-swap <app1-name> <app2-name> [-f/--force]",
+		Usage:   "app-swap <app1-name> <app2-name> [-f/--force] [--i-know-this-is-prod]",
 		Desc:    "Swap routes between two apps. Use force if you want to swap apps with different numbers of units or diferent platform without confirmation",
 		MinArgs: 2,
 	}
@@ -34,12 +38,16 @@ func (s *appSwap) Flags() *gnuflag.FlagSet {
 		s.fs = gnuflag.NewFlagSet("", gnuflag.ExitOnError)
 		s.fs.BoolVar(&s.force, "force", false, "Force Swap among apps with different number of units or different platform.")
 		s.fs.BoolVar(&s.force, "f", false, "Force Swap among apps with different number of units or different platform.")
+		s.fs = cmd.MergeFlagSet(s.fs, s.protectedCheck.Flags())
 	}
 	return s.fs
 }
 
 func (s *appSwap) Run(context *cmd.Context, client *cmd.Client) error {
-	url, err := cmd.GetURL(fmt.Sprintf("/swap?app1=%s&app2=%s&force=%t", context.Args[0], context.Args[1], s.force))
+	if ok, err := s.confirmProtected(context, context.Args[0], context.Args[1]); !ok || err != nil {
+		return err
+	}
+	url, err := cmd.GetURL(tsuruClient.Path("/swap?app1=%s&app2=%s&force=%t", context.Args[0], context.Args[1], s.force))
 	if err != nil {
 		return err
 	}
@@ -50,7 +58,7 @@ func (s *appSwap) Run(context *cmd.Context, client *cmd.Client) error {
 			fmt.Fprintf(context.Stdout, "WARNING: %s.\nSwap anyway? (y/n) ", strings.TrimRight(e.Message, "\n"))
 			fmt.Fscanf(context.Stdin, "%s", &answer)
 			if answer == "y" || answer == "yes" {
-				url, _ = cmd.GetURL(fmt.Sprintf("/swap?app1=%s&app2=%s&force=%t", context.Args[0], context.Args[1], true))
+				url, _ = cmd.GetURL(tsuruClient.Path("/swap?app1=%s&app2=%s&force=%t", context.Args[0], context.Args[1], true))
 				return makeSwap(client, url)
 			}
 			fmt.Fprintln(context.Stdout, "swap aborted.")
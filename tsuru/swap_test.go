@@ -16,7 +16,7 @@ import (
 func (s *S) TestSwapInfo(c *gocheck.C) {
 	expected := &cmd.Info{
 		Name:    "app-swap",
-		Usage:   "app-swap <app1-name> <app2-name> [-f/--force]",
+		Usage:   "app-swap <app1-name> <app2-name> [-f/--force] [--i-know-this-is-prod]",
 		Desc:    "Swap routes between two apps. Use force if you want to swap apps with different numbers of units or diferent platform without confirmation",
 		MinArgs: 2,
 	}
@@ -47,6 +47,24 @@ func (s *S) TestSwap(c *gocheck.C) {
 	c.Assert(buf.String(), gocheck.Equals, expected)
 }
 
+func (s *S) TestSwapEscapesAppNames(c *gocheck.C) {
+	var called bool
+	trans := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Status: http.StatusOK, Message: ""},
+		CondFunc: func(r *http.Request) bool {
+			called = true
+			query := r.URL.Query()
+			return query.Get("app1") == "app+1" && query.Get("app2") == "app&2" && query.Get("force") == "false"
+		},
+	}
+	context := cmd.Context{Args: []string{"app+1", "app&2"}, Stdout: &bytes.Buffer{}}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := appSwap{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+}
+
 func (s *S) TestSwapWhenAppsAreNotEqual(c *gocheck.C) {
 	var buf bytes.Buffer
 	var called int
@@ -0,0 +1,94 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/tsuru/tsuru/cmd"
+)
+
+// mutatingCommand is implemented by the commands that change something in
+// the tsuru server, which print the target they act on before running.
+type mutatingCommand interface {
+	mutatesTarget()
+}
+
+// isMutating tells whether a command, or the command a deprecated name
+// refers to, is a mutating command.
+func isMutating(command cmd.Command) bool {
+	if deprecated, ok := command.(*cmd.DeprecatedCommand); ok {
+		command = deprecated.Command
+	}
+	_, ok := command.(mutatingCommand)
+	return ok
+}
+
+// pinProjectTarget makes the target of the project in dir, given in its
+// .tsuru.yaml file, the target of the commands, by setting TSURU_TARGET. A
+// TSURU_TARGET already set in the environment takes precedence. It returns
+// where the active target comes from: the environment variable, the path of
+// the project file, or an empty string for the target chosen with target-set.
+func pinProjectTarget(dir string) (string, error) {
+	if os.Getenv("TSURU_TARGET") != "" {
+		return "TSURU_TARGET", nil
+	}
+	config, path, err := findProjectConfig(dir)
+	if err != nil {
+		return "", err
+	}
+	if config.Target == "" {
+		return "", nil
+	}
+	target, err := resolveTarget(config.Target)
+	if err != nil {
+		return "", err
+	}
+	return path, os.Setenv("TSURU_TARGET", target)
+}
+
+// resolveTarget returns the address of the target with the given label, as
+// added with target-add. Values that aren't labels are taken as addresses.
+func resolveTarget(target string) (string, error) {
+	f, err := filesystem().Open(cmd.JoinWithUserDir(".tsuru_targets"))
+	if os.IsNotExist(err) {
+		return target, nil
+	} else if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.Split(strings.TrimSpace(line), "\t")
+		if len(parts) == 2 && parts[0] == target {
+			return parts[1], nil
+		}
+	}
+	return target, nil
+}
+
+// printTarget tells the target a mutating command is about to act on, and
+// where it comes from.
+func printTarget(w io.Writer, manager *cmd.Manager, args []string, source string) {
+	if len(args) == 0 || !isMutating(manager.Commands[args[0]]) {
+		return
+	}
+	target, err := cmd.GetURL("")
+	if err != nil {
+		return
+	}
+	if source != "" {
+		fmt.Fprintf(w, "Target: %s (from %s)\n", target, source)
+	} else {
+		fmt.Fprintf(w, "Target: %s\n", target)
+	}
+}
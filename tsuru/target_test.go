@@ -0,0 +1,101 @@
+// Copyright 2015 tsuru-client authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/tsuru/tsuru/fs/fstest"
+	"launchpad.net/gocheck"
+)
+
+func (s *S) TestPinProjectTarget(c *gocheck.C) {
+	fsystem = &fstest.RecordingFs{FileContent: "staging\thttps://staging.example.com\nprod\thttps://tsuru.example.com\n"}
+	defer func() { fsystem = nil }()
+	defer os.Unsetenv("TSURU_TARGET")
+	dir := c.MkDir()
+	path := filepath.Join(dir, projectConfigFileName)
+	err := ioutil.WriteFile(path, []byte("target: prod\n"), 0644)
+	c.Assert(err, gocheck.IsNil)
+	source, err := pinProjectTarget(dir)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(source, gocheck.Equals, path)
+	c.Assert(os.Getenv("TSURU_TARGET"), gocheck.Equals, "https://tsuru.example.com")
+}
+
+func (s *S) TestPinProjectTargetAddress(c *gocheck.C) {
+	fsystem = &fstest.FileNotFoundFs{}
+	defer func() { fsystem = nil }()
+	defer os.Unsetenv("TSURU_TARGET")
+	dir := c.MkDir()
+	err := ioutil.WriteFile(filepath.Join(dir, projectConfigFileName), []byte("target: tsuru.example.com\n"), 0644)
+	c.Assert(err, gocheck.IsNil)
+	_, err = pinProjectTarget(dir)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(os.Getenv("TSURU_TARGET"), gocheck.Equals, "tsuru.example.com")
+}
+
+func (s *S) TestPinProjectTargetEnvironmentVariableWins(c *gocheck.C) {
+	os.Setenv("TSURU_TARGET", "https://other.example.com")
+	defer os.Unsetenv("TSURU_TARGET")
+	dir := c.MkDir()
+	err := ioutil.WriteFile(filepath.Join(dir, projectConfigFileName), []byte("target: prod\n"), 0644)
+	c.Assert(err, gocheck.IsNil)
+	source, err := pinProjectTarget(dir)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(source, gocheck.Equals, "TSURU_TARGET")
+	c.Assert(os.Getenv("TSURU_TARGET"), gocheck.Equals, "https://other.example.com")
+}
+
+func (s *S) TestPinProjectTargetWithoutProjectTarget(c *gocheck.C) {
+	source, err := pinProjectTarget(c.MkDir())
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(source, gocheck.Equals, "")
+	c.Assert(os.Getenv("TSURU_TARGET"), gocheck.Equals, "")
+}
+
+func (s *S) TestPrintTarget(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	var buf bytes.Buffer
+	printTarget(&buf, manager, []string{"app-remove", "-a", "myapp"}, "")
+	c.Assert(buf.String(), gocheck.Equals, "Target: http://localhost:8080\n")
+	buf.Reset()
+	printTarget(&buf, manager, []string{"deploy", "."}, "/home/me/myapp/.tsuru.yaml")
+	c.Assert(buf.String(), gocheck.Equals, "Target: http://localhost:8080 (from /home/me/myapp/.tsuru.yaml)\n")
+}
+
+func (s *S) TestPrintTargetReadOnlyCommand(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	var buf bytes.Buffer
+	printTarget(&buf, manager, []string{"app-list"}, "")
+	printTarget(&buf, manager, nil, "")
+	c.Assert(buf.String(), gocheck.Equals, "")
+}
+
+func (s *S) TestMutatingCommands(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	mutating := []string{
+		"app-create", "app-remove", "app-grant", "app-revoke", "app-stop", "stop",
+		"app-start", "start", "app-restart", "restart", "app-run", "run", "app-swap",
+		"swap", "app-set-team-owner", "app-deploy", "deploy", "app-deploy-rollback",
+		"cname-add", "add-cname", "cname-remove", "remove-cname", "unit-add",
+		"unit-remove", "env-set", "env-unset", "autoscale-enable", "autoscale-disable",
+		"autoscale-config", "service-add", "service-remove", "service-bind", "bind",
+		"service-unbind", "unbind", "key-add", "key-remove", "user-create",
+		"user-remove", "change-password", "reset-password", "token-regenerate",
+		"team-create", "team-remove", "team-user-add", "team-user-remove",
+	}
+	for _, name := range mutating {
+		command, ok := manager.Commands[name]
+		c.Check(ok, gocheck.Equals, true, gocheck.Commentf("command %q", name))
+		c.Check(isMutating(command), gocheck.Equals, true, gocheck.Commentf("command %q", name))
+	}
+	for _, name := range []string{"app-list", "app-info", "log", "env-get", "app-deploy-list", "target-list"} {
+		c.Check(isMutating(manager.Commands[name]), gocheck.Equals, false, gocheck.Commentf("command %q", name))
+	}
+}